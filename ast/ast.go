@@ -8,6 +8,12 @@
 package ast
 
 import (
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
 	"github.com/rthornton128/calc/token"
 )
 
@@ -47,8 +53,24 @@ type CallExpr struct {
 	Args []Expr
 }
 
+// A Comment represents a single ;-style line comment, a #| |# block comment
+// or a #; datum comment. Text includes the comment markers but not the
+// newline terminating a line comment. Semicolon is the position of the
+// first character of the comment.
+type Comment struct {
+	Semicolon token.Pos
+	Text      string
+}
+
+// A CommentGroup represents a sequence of comments with no tokens and no
+// empty lines between them.
+type CommentGroup struct {
+	List []*Comment
+}
+
 type DeclExpr struct {
 	Expression
+	Doc    *CommentGroup // may be nil
 	Decl   token.Pos
 	Name   *Ident
 	Type   *Ident
@@ -68,7 +90,11 @@ type ExprList struct {
 }
 
 type File struct {
-	Scope *Scope
+	FileStart token.Pos // start of the file
+	FileEnd   token.Pos // end of the file
+	Scope     *Scope
+	Imports   []*ImportExpr   // imports in this file
+	Comments  []*CommentGroup // all comments in the source file
 }
 
 type Ident struct {
@@ -87,6 +113,17 @@ type IfExpr struct {
 	Scope *Scope
 }
 
+// An ImportExpr represents the import of a package. Name is the name the
+// package is referred to by within the importing file and is nil if the
+// last element of the import path is used.
+type ImportExpr struct {
+	Expression
+	Import token.Pos
+	Name   *Ident    // may be nil
+	Path   *BasicLit // a STRING literal
+	Pkg    *Package  // imported package, set once loaded; may be nil
+}
+
 type Object struct {
 	NamePos token.Pos
 	Name    string
@@ -99,18 +136,21 @@ type Object struct {
 type ObKind int
 
 type Package struct {
+	Name  string // package name
+	Path  string // import path; empty for the main package
 	Scope *Scope
 	Files []*File
 }
 
 type Scope struct {
-	Parent *Scope
-	Table  map[string]*Object
+	Parent  *Scope
+	Table   map[string]*Object
+	objects []*Object // in order of insertion
 }
 
 type UnaryExpr struct {
 	OpPos token.Pos
-	Op    string
+	Op    token.Token
 	Value Expr
 }
 
@@ -121,19 +161,64 @@ type VarExpr struct {
 	Object *Object
 }
 
-func (b *BasicLit) Pos() token.Pos   { return b.LitPos }
-func (e *Expression) Pos() token.Pos { return e.Opening }
-func (f *File) Pos() token.Pos       { return token.NoPos }
-func (i *Ident) Pos() token.Pos      { return i.NamePos }
-func (p *Package) Pos() token.Pos    { return token.NoPos }
-func (u *UnaryExpr) Pos() token.Pos  { return u.OpPos }
+// Pos returns the position of the first character belonging to the node.
+// End returns the position of the first character immediately after the
+// node.
+
+func (b *BasicLit) Pos() token.Pos     { return b.LitPos }
+func (c *Comment) Pos() token.Pos      { return c.Semicolon }
+func (g *CommentGroup) Pos() token.Pos { return g.List[0].Pos() }
+func (e *Expression) Pos() token.Pos   { return e.Opening }
+func (f *File) Pos() token.Pos         { return f.FileStart }
+func (i *Ident) Pos() token.Pos        { return i.NamePos }
+func (u *UnaryExpr) Pos() token.Pos    { return u.OpPos }
+
+func (b *BasicLit) End() token.Pos     { return b.LitPos + token.Pos(len(b.Lit)) }
+func (c *Comment) End() token.Pos      { return c.Semicolon + token.Pos(len(c.Text)) }
+func (g *CommentGroup) End() token.Pos { return g.List[len(g.List)-1].End() }
+func (f *File) End() token.Pos         { return f.FileEnd }
+func (i *Ident) End() token.Pos        { return i.NamePos + token.Pos(len(i.Name)) }
+
+func (e *Expression) End() token.Pos {
+	if !e.Closing.Valid() {
+		return token.NoPos
+	}
+	return e.Closing + 1
+}
+
+// Pos returns the start of the first file in the package
+func (p *Package) Pos() token.Pos {
+	pos := token.NoPos
+	for _, f := range p.Files {
+		if !pos.Valid() || (f.Pos().Valid() && f.Pos() < pos) {
+			pos = f.Pos()
+		}
+	}
+	return pos
+}
 
-func (b *BasicLit) End() token.Pos   { return b.LitPos + token.Pos(len(b.Lit)) }
-func (e *Expression) End() token.Pos { return e.Closing }
-func (f *File) End() token.Pos       { return token.NoPos }
-func (i *Ident) End() token.Pos      { return i.NamePos + token.Pos(len(i.Name)) }
-func (p *Package) End() token.Pos    { return token.NoPos }
-func (u *UnaryExpr) End() token.Pos  { return u.Value.End() }
+// End returns the end of the last file in the package
+func (p *Package) End() token.Pos {
+	end := token.NoPos
+	for _, f := range p.Files {
+		if f.End() > end {
+			end = f.End()
+		}
+	}
+	return end
+}
+
+func (u *UnaryExpr) End() token.Pos {
+	if u.Value == nil {
+		return u.OpPos + token.Pos(len(u.Op.String()))
+	}
+	return u.Value.End()
+}
+
+// RangeOf returns the range of source code covered by n
+func RangeOf(n Node) token.Range {
+	return token.Range{Start: n.Pos(), End: n.End()}
+}
 
 func (b *BasicLit) exprNode()   {}
 func (e *Expression) exprNode() {}
@@ -143,8 +228,85 @@ func (u *UnaryExpr) exprNode()  {}
 const (
 	Decl ObKind = iota
 	Var
+	Pkg
 )
 
+// IsExported reports whether name is exported from its package, that is,
+// whether it begins with an upper-case letter. Only exported names may be
+// referred to from other packages.
+func IsExported(name string) bool {
+	ch, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsUpper(ch)
+}
+
+// SplitQualified splits a qualified identifier, like math.Square, into its
+// package name and the name it selects. Pkg is empty if name is not
+// qualified.
+func SplitQualified(name string) (pkg, sel string) {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// ImportPath returns the unquoted import path or an empty string if the
+// path is not a valid string literal.
+func (i *ImportExpr) ImportPath() string {
+	if i.Path == nil {
+		return ""
+	}
+	s, err := strconv.Unquote(i.Path.Lit)
+	if err != nil {
+		return ""
+	}
+	return s
+}
+
+// LocalName returns the name the imported package is referred to by
+func (i *ImportExpr) LocalName() string {
+	if i.Name != nil {
+		return i.Name.Name
+	}
+	return path.Base(i.ImportPath())
+}
+
+// Text returns the text of the comment group with the comment markers and
+// a single following space, if present, removed from each line. Datum
+// comments are omitted. Leading and trailing empty lines are dropped.
+func (g *CommentGroup) Text() string {
+	if g == nil {
+		return ""
+	}
+	lines := make([]string, 0, len(g.List))
+	for _, c := range g.List {
+		var text []string
+		switch {
+		case strings.HasPrefix(c.Text, "#;"):
+			continue // commented out code is not documentation
+		case strings.HasPrefix(c.Text, "#|"):
+			text = strings.Split(strings.TrimSuffix(c.Text[2:], "|#"), "\n")
+		default:
+			text = []string{strings.TrimLeft(c.Text, ";")}
+		}
+		for _, line := range text {
+			if len(line) > 0 && line[0] == ' ' {
+				line = line[1:]
+			}
+			lines = append(lines, strings.TrimRight(line, " \t\r"))
+		}
+	}
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
 func NewScope(parent *Scope) *Scope {
 	return &Scope{Parent: parent, Table: make(map[string]*Object)}
 }
@@ -154,6 +316,7 @@ func (s *Scope) Insert(ob *Object) *Object {
 		return old
 	}
 	s.Table[ob.Name] = ob
+	s.objects = append(s.objects, ob)
 	return nil
 }
 
@@ -165,6 +328,33 @@ func (s *Scope) Lookup(ident string) *Object {
 	return s.Parent.Lookup(ident)
 }
 
+// Resolve is like Lookup but also resolves qualified identifiers, like
+// math.Square, to the object declared in the imported package. Nil is
+// returned if the package has not been loaded. Resolve does not check
+// that the object is exported.
+func (s *Scope) Resolve(name string) *Object {
+	pkg, sel := SplitQualified(name)
+	if pkg == "" {
+		return s.Lookup(name)
+	}
+	ob := s.Lookup(pkg)
+	if ob == nil || ob.Kind != Pkg {
+		return nil
+	}
+	imp, ok := ob.Value.(*ImportExpr)
+	if !ok || imp.Pkg == nil || imp.Pkg.Scope == nil {
+		return nil
+	}
+	return imp.Pkg.Scope.Table[sel]
+}
+
+// Objects returns the objects declared in the scope in the order they were
+// inserted. Objects should be used rather than ranging over Table whenever
+// the order of iteration is observable, such as when generating code.
+func (s *Scope) Objects() []*Object {
+	return s.objects
+}
+
 func (s *Scope) Size() int {
 	return len(s.Table)
 }
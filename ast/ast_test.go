@@ -8,6 +8,8 @@
 package ast_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"testing"
 
 	"github.com/rthornton128/calc/ast"
@@ -51,7 +53,92 @@ func TestBinaryExpr(t *testing.T) {
 	if b.Pos() != token.Pos(1) {
 		t.Fatal("BinaryExpr: Expected: 1 Got:", b.Pos())
 	}
-	if b.End() != token.Pos(7) {
-		t.Fatal("BinaryExpr: Expected: 7 Got:", b.End())
+	if b.End() != token.Pos(8) {
+		t.Fatal("BinaryExpr: Expected: 8 Got:", b.End())
+	}
+}
+
+func TestFprint(t *testing.T) {
+	// (+ 3 -x)
+	b := &ast.BinaryExpr{
+		Expression: ast.Expression{Opening: token.Pos(1), Closing: token.Pos(9)},
+		Op:         token.ADD,
+		OpPos:      token.Pos(2),
+		List: []ast.Expr{
+			&ast.BasicLit{LitPos: token.Pos(4), Kind: token.INTEGER, Lit: "3"},
+			&ast.UnaryExpr{OpPos: token.Pos(6), Op: token.SUB,
+				Value: &ast.Ident{NamePos: token.Pos(7), Name: "x"}},
+		},
+	}
+
+	var tests = []struct {
+		format ast.Format
+		exp    string
+	}{
+		{ast.TreeFormat, `BinaryExpr 1-10
+  Op: "+"
+  List: [2]
+    0: BasicLit 4-5
+      Kind: "Integer"
+      Lit: "3"
+    1: UnaryExpr 6-8
+      Op: "-"
+      Value: Ident 7-8
+        Name: "x"
+`},
+		{ast.SExprFormat, `(BinaryExpr :pos "1-10" :Op "+"
+  :List ((BasicLit :pos "4-5" :Kind "Integer" :Lit "3")
+   (UnaryExpr :pos "6-8" :Op "-"
+    :Value (Ident :pos "7-8" :Name "x"))))
+`},
+	}
+	for _, v := range tests {
+		var buf bytes.Buffer
+		if err := ast.Fprint(&buf, nil, b, v.format); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != v.exp {
+			t.Fatal("Expected:\n" + v.exp + "Got:\n" + buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := ast.Fprint(&buf, nil, b, ast.JSONFormat); err != nil {
+		t.Fatal(err)
+	}
+	var res struct {
+		Node string
+		List []struct {
+			Node string
+			Lit  string
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Node != "BinaryExpr" || len(res.List) != 2 || res.List[0].Lit != "3" ||
+		res.List[1].Node != "UnaryExpr" {
+		t.Fatal("Unexpected JSON:", buf.String())
+	}
+}
+
+func TestScopeObjects(t *testing.T) {
+	names := []string{"zeta", "alpha", "mu", "beta", "omega", "gamma"}
+	s := ast.NewScope(nil)
+	for _, name := range names {
+		s.Insert(&ast.Object{Name: name, Kind: ast.Decl})
+	}
+	if old := s.Insert(&ast.Object{Name: "mu"}); old == nil {
+		t.Fatal("Expected: redeclaration of mu")
+	}
+
+	obs := s.Objects()
+	if len(obs) != len(names) {
+		t.Fatal("Expected:", len(names), "objects Got:", len(obs))
+	}
+	for i, ob := range obs {
+		if ob.Name != names[i] {
+			t.Fatal(i, "- Expected:", names[i], "Got:", ob.Name)
+		}
 	}
 }
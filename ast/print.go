@@ -8,23 +8,314 @@
 package ast
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/rthornton128/calc/token"
+)
+
+// Format selects the output format of Fprint
+type Format int
+
+const (
+	TreeFormat  Format = iota // indented, human readable tree
+	JSONFormat                // JSON objects
+	SExprFormat               // S-expressions
 )
 
+// Print writes a tree dump of node to standard output
 func Print(node Node) {
-	Walk(node, print)
+	Fprint(os.Stdout, nil, node, TreeFormat)
+}
+
+// Fprint writes a dump of node and all its children to w in the given
+// format. If fset is not nil, positions are printed as file:line:column;
+// otherwise they are printed as raw offsets.
+func Fprint(w io.Writer, fset *token.FileSet, node Node, format Format) error {
+	d := (&dumper{fset: fset}).dump(node)
+
+	switch format {
+	case JSONFormat:
+		var buf, out bytes.Buffer
+		writeJSON(&buf, d)
+		if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+			return err
+		}
+		out.WriteByte('\n')
+		_, err := out.WriteTo(w)
+		return err
+	case SExprFormat:
+		bw := bufio.NewWriter(w)
+		writeSExpr(bw, d, 0)
+		bw.WriteByte('\n')
+		return bw.Flush()
+	default:
+		bw := bufio.NewWriter(w)
+		writeTree(bw, d, 0)
+		return bw.Flush()
+	}
+}
+
+// dumpNode is a format independent representation of a Node. Field values
+// are either strings, *dumpNode or []*dumpNode.
+type dumpNode struct {
+	typ      string
+	pos, end string
+	span     string // pos and end in compact form
+	fields   []dumpField
+}
+
+type dumpField struct {
+	name  string
+	value interface{}
+}
+
+type dumper struct {
+	fset *token.FileSet
 }
 
-func print(node Node) {
-	switch n := node.(type) {
+func (d *dumper) position(p token.Pos) string {
+	switch {
+	case !p.Valid():
+		return ""
+	case d.fset == nil:
+		return strconv.Itoa(int(p))
+	}
+	return d.fset.Position(p).String()
+}
+
+func (d *dumper) dump(node Node) *dumpNode {
+	if isNil(node) {
+		return nil
+	}
+
+	n := &dumpNode{
+		typ: strings.TrimPrefix(reflect.TypeOf(node).String(), "*ast."),
+		pos: d.position(node.Pos()),
+	}
+	add := func(name string, value interface{}) {
+		n.fields = append(n.fields, dumpField{name, value})
+	}
+
+	switch t := node.(type) {
+	case *AssignExpr:
+		add("Name", d.dump(t.Name))
+		add("Value", d.dump(t.Value))
 	case *BasicLit:
-		fmt.Println("BasicType:", n.LitPos, n.Lit)
+		add("Kind", t.Kind.String())
+		add("Lit", t.Lit)
 	case *BinaryExpr:
-		fmt.Println("BinaryExpr:", n.OpPos, n.Op)
+		add("Op", t.Op.String())
+		add("List", d.dumpExprs(t.List))
+	case *CallExpr:
+		add("Name", d.dump(t.Name))
+		add("Args", d.dumpExprs(t.Args))
+	case *Comment:
+		add("Text", t.Text)
+	case *CommentGroup:
+		var list []*dumpNode
+		for _, c := range t.List {
+			list = append(list, d.dump(c))
+		}
+		add("List", list)
+	case *DeclExpr:
+		add("Doc", d.dump(t.Doc))
+		add("Name", d.dump(t.Name))
+		var params []*dumpNode
+		for _, p := range t.Params {
+			params = append(params, d.dumpParam(p))
+		}
+		add("Params", params)
+		add("Type", d.dump(t.Type))
+		add("Body", d.dump(t.Body))
+	case *ExprList:
+		add("List", d.dumpExprs(t.List))
 	case *File:
-		fmt.Println("File:")
-	default:
-		fmt.Println("dunno what I got...that can't be good")
-		fmt.Println(n)
+		add("Decls", d.dumpScope(t.Scope))
+		var comments []*dumpNode
+		for _, c := range t.Comments {
+			comments = append(comments, d.dump(c))
+		}
+		add("Comments", comments)
+	case *Ident:
+		add("Name", t.Name)
+	case *IfExpr:
+		add("Cond", d.dump(t.Cond))
+		add("Type", d.dump(t.Type))
+		add("Then", d.dump(t.Then))
+		add("Else", d.dump(t.Else))
+	case *ImportExpr:
+		add("Name", d.dump(t.Name))
+		add("Path", d.dump(t.Path))
+	case *Package:
+		add("Name", t.Name)
+		add("Path", t.Path)
+		add("Decls", d.dumpScope(t.Scope))
+	case *UnaryExpr:
+		add("Op", t.Op.String())
+		add("Value", d.dump(t.Value))
+	case *VarExpr:
+		add("Name", d.dump(t.Name))
+		if t.Object != nil {
+			add("Type", d.dump(t.Object.Type))
+			add("Value", d.dump(t.Object.Value))
+		}
+	}
+
+	n.span = n.pos
+	if end := node.End(); end.Valid() && end != node.Pos() {
+		n.end = d.position(end)
+		n.span += "-" + n.end
+		if d.fset != nil && node.Pos().Valid() {
+			p, e := d.fset.Position(node.Pos()), d.fset.Position(end)
+			if p.Filename == e.Filename {
+				n.span = fmt.Sprintf("%s-%d:%d", p, e.Row, e.Col)
+			}
+		}
+	}
+	return n
+}
+
+func (d *dumper) dumpExprs(list []Expr) []*dumpNode {
+	var nodes []*dumpNode
+	for _, e := range list {
+		nodes = append(nodes, d.dump(e))
+	}
+	return nodes
+}
+
+// dumpParam dumps a parameter along with the type recorded in its object
+func (d *dumper) dumpParam(p *Ident) *dumpNode {
+	n := d.dump(p)
+	if p.Object != nil {
+		n.fields = append(n.fields, dumpField{"Type", d.dump(p.Object.Type)})
+	}
+	return n
+}
+
+// dumpScope dumps the declarations of a scope in the order they were made
+func (d *dumper) dumpScope(s *Scope) []*dumpNode {
+	if s == nil {
+		return nil
+	}
+	var nodes []*dumpNode
+	for _, ob := range s.Objects() {
+		if !isNil(ob.Value) {
+			nodes = append(nodes, d.dump(ob.Value))
+		}
+	}
+	return nodes
+}
+
+/* Output formats */
+
+func writeTree(w *bufio.Writer, n *dumpNode, depth int) {
+	if n == nil {
+		w.WriteString("nil\n")
+		return
+	}
+	w.WriteString(n.typ)
+	if n.span != "" {
+		w.WriteString(" " + n.span)
+	}
+	w.WriteByte('\n')
+
+	indent := strings.Repeat("  ", depth+1)
+	for _, f := range n.fields {
+		w.WriteString(indent + f.name + ": ")
+		switch v := f.value.(type) {
+		case string:
+			w.WriteString(strconv.Quote(v) + "\n")
+		case *dumpNode:
+			writeTree(w, v, depth+1)
+		case []*dumpNode:
+			fmt.Fprintf(w, "[%d]\n", len(v))
+			for i, x := range v {
+				fmt.Fprintf(w, "%s  %d: ", indent, i)
+				writeTree(w, x, depth+2)
+			}
+		}
+	}
+}
+
+func writeJSON(w *bytes.Buffer, n *dumpNode) {
+	if n == nil {
+		w.WriteString("null")
+		return
+	}
+	w.WriteString(`{"Node":`)
+	writeJSONString(w, n.typ)
+	if n.pos != "" {
+		w.WriteString(`,"Pos":`)
+		writeJSONString(w, n.pos)
+	}
+	if n.end != "" {
+		w.WriteString(`,"End":`)
+		writeJSONString(w, n.end)
+	}
+	for _, f := range n.fields {
+		w.WriteString(`,"` + f.name + `":`)
+		switch v := f.value.(type) {
+		case string:
+			writeJSONString(w, v)
+		case *dumpNode:
+			writeJSON(w, v)
+		case []*dumpNode:
+			w.WriteByte('[')
+			for i, x := range v {
+				if i > 0 {
+					w.WriteByte(',')
+				}
+				writeJSON(w, x)
+			}
+			w.WriteByte(']')
+		}
+	}
+	w.WriteByte('}')
+}
+
+func writeJSONString(w *bytes.Buffer, s string) {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	w.Truncate(w.Len() - 1) // remove newline added by Encode
+}
+
+func writeSExpr(w *bufio.Writer, n *dumpNode, depth int) {
+	if n == nil {
+		w.WriteString("nil")
+		return
+	}
+	w.WriteString("(" + n.typ)
+	if n.span != "" {
+		w.WriteString(" :pos " + strconv.Quote(n.span))
+	}
+
+	indent := "\n" + strings.Repeat("  ", depth+1)
+	for _, f := range n.fields {
+		switch v := f.value.(type) {
+		case string:
+			w.WriteString(" :" + f.name + " " + strconv.Quote(v))
+		case *dumpNode:
+			w.WriteString(indent + ":" + f.name + " ")
+			writeSExpr(w, v, depth+1)
+		case []*dumpNode:
+			w.WriteString(indent + ":" + f.name + " (")
+			for i, x := range v {
+				if i > 0 {
+					w.WriteString(indent + " ")
+				}
+				writeSExpr(w, x, depth+1)
+			}
+			w.WriteByte(')')
+		}
 	}
+	w.WriteByte(')')
 }
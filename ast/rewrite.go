@@ -0,0 +1,211 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package ast
+
+// An ApplyFunc is invoked by Apply for each non-nil node n, before and/or
+// after the node's children, using a Cursor describing the
+// current node and providing operations on it.
+//
+// The return value of ApplyFunc controls the syntax tree traversal.
+// See Apply for details.
+type ApplyFunc func(*Cursor) bool
+
+// Apply traverses a syntax tree recursively, starting with root, and
+// calling pre and post for each non-nil node: pre is called before the
+// children of a node are traversed and post afterwards. Either may be nil.
+//
+// If pre returns false, no children are traversed and post is not called
+// for that node. If post returns false, traversal is terminated and Apply
+// returns immediately.
+//
+// Only fields of known AST node types are traversed. The node given to
+// Cursor.Replace must be assignable to the field being replaced; for
+// instance, a name may only be replaced by another *Ident. Apply returns
+// the possibly modified root node.
+func Apply(root Node, pre, post ApplyFunc) (result Node) {
+	defer func() {
+		if r := recover(); r != nil && r != abort {
+			panic(r)
+		}
+	}()
+
+	a := &application{pre: pre, post: post}
+	result = root
+	a.apply(Cursor{
+		name:    "Root",
+		node:    root,
+		replace: func(n Node) { result = n },
+	})
+	return
+}
+
+var abort = new(int) // singleton, to signal termination of Apply
+
+// A Cursor describes a node encountered during Apply. Information about
+// the node and its parent is available from the Node, Parent, Name and
+// Index methods.
+type Cursor struct {
+	parent  Node
+	name    string
+	node    Node
+	replace func(Node) // sets the field holding node, nil for list elements
+	list    *[]Expr    // the list holding node, nil for fields
+	iter    *iterator
+	deleted bool
+}
+
+type iterator struct {
+	index, step int
+}
+
+// Node returns the current Node
+func (c *Cursor) Node() Node { return c.node }
+
+// Parent returns the parent of the current Node
+func (c *Cursor) Parent() Node { return c.parent }
+
+// Name returns the name of the parent Node field that contains the current
+// Node, such as "Args" for an argument of a CallExpr
+func (c *Cursor) Name() string { return c.name }
+
+// Index reports the index >= 0 of the current Node in the slice of Nodes
+// that contains it, or a value < 0 if the current Node is not part of a
+// slice.
+func (c *Cursor) Index() int {
+	if c.iter != nil {
+		return c.iter.index
+	}
+	return -1
+}
+
+// Replace replaces the current Node with n. The replacement node is not
+// walked by Apply.
+func (c *Cursor) Replace(n Node) {
+	if c.list != nil {
+		e, ok := n.(Expr)
+		if !ok {
+			panic("Replace: node is not an expression")
+		}
+		(*c.list)[c.iter.index] = e
+	} else {
+		c.replace(n)
+	}
+	c.node = n
+}
+
+// Delete deletes the current Node from its containing slice. Only
+// expressions in the list of an ExprList, the operands of a BinaryExpr and
+// the arguments of a CallExpr may be deleted.
+func (c *Cursor) Delete() {
+	if c.list == nil {
+		panic("Delete: node not contained in an expression list")
+	}
+	i := c.iter.index
+	l := *c.list
+	copy(l[i:], l[i+1:])
+	l[len(l)-1] = nil
+	*c.list = l[:len(l)-1]
+	c.iter.step--
+	c.deleted = true
+}
+
+type application struct {
+	pre, post ApplyFunc
+}
+
+func (a *application) apply(c Cursor) {
+	if isNil(c.node) {
+		return
+	}
+	if a.pre != nil && (!a.pre(&c) || c.deleted) {
+		return
+	}
+
+	parent := c.node
+	switch n := parent.(type) {
+	case *AssignExpr:
+		a.applyIdent(n, "Name", &n.Name)
+		a.applyExpr(n, "Value", &n.Value)
+	case *BinaryExpr:
+		a.applyList(n, "List", &n.List)
+	case *CallExpr:
+		a.applyIdent(n, "Name", &n.Name)
+		a.applyList(n, "Args", &n.Args)
+	case *CommentGroup:
+		for i := range n.List {
+			cp := &n.List[i]
+			a.apply(Cursor{parent: n, name: "List", node: *cp,
+				replace: func(x Node) { *cp = x.(*Comment) }})
+		}
+	case *DeclExpr:
+		a.apply(Cursor{parent: n, name: "Doc", node: n.Doc,
+			replace: func(x Node) { n.Doc = x.(*CommentGroup) }})
+		a.applyIdent(n, "Name", &n.Name)
+		for i := range n.Params {
+			a.applyIdent(n, "Params", &n.Params[i])
+		}
+		a.applyIdent(n, "Type", &n.Type)
+		a.applyExpr(n, "Body", &n.Body)
+	case *ExprList:
+		a.applyList(n, "List", &n.List)
+	case *File:
+		a.applyScope(n, n.Scope)
+	case *IfExpr:
+		a.applyExpr(n, "Cond", &n.Cond)
+		a.applyIdent(n, "Type", &n.Type)
+		a.applyExpr(n, "Then", &n.Then)
+		a.applyExpr(n, "Else", &n.Else)
+	case *ImportExpr:
+		a.applyIdent(n, "Name", &n.Name)
+		a.apply(Cursor{parent: n, name: "Path", node: n.Path,
+			replace: func(x Node) { n.Path = x.(*BasicLit) }})
+	case *Package:
+		a.applyScope(n, n.Scope)
+	case *UnaryExpr:
+		a.applyExpr(n, "Value", &n.Value)
+	case *VarExpr:
+		a.applyIdent(n, "Name", &n.Name)
+		if n.Object != nil {
+			a.applyIdent(n, "Type", &n.Object.Type)
+			a.applyExpr(n, "Value", &n.Object.Value)
+		}
+	}
+
+	if a.post != nil && !a.post(&c) {
+		panic(abort)
+	}
+}
+
+func (a *application) applyIdent(parent Node, name string, id **Ident) {
+	a.apply(Cursor{parent: parent, name: name, node: *id,
+		replace: func(n Node) { *id = n.(*Ident) }})
+}
+
+func (a *application) applyExpr(parent Node, name string, e *Expr) {
+	a.apply(Cursor{parent: parent, name: name, node: *e,
+		replace: func(n Node) { *e = n.(Expr) }})
+}
+
+func (a *application) applyList(parent Node, name string, list *[]Expr) {
+	iter := &iterator{}
+	for iter.index < len(*list) {
+		iter.step = 1
+		a.apply(Cursor{parent: parent, name: name, node: (*list)[iter.index],
+			list: list, iter: iter})
+		iter.index += iter.step
+	}
+}
+
+func (a *application) applyScope(parent Node, s *Scope) {
+	if s == nil {
+		return
+	}
+	for _, ob := range s.Objects() {
+		a.applyExpr(parent, "Decls", &ob.Value)
+	}
+}
@@ -7,60 +7,154 @@
 
 package ast
 
-import "reflect"
+// A Visitor's Visit method is invoked for each node encountered by
+// WalkVisitor. If the result visitor w is not nil, WalkVisitor visits each
+// of the children of node with the visitor w, followed by a call of
+// w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
 
 type Func func(Node)
 
+// Walk traverses an AST in depth-first order, calling f for each node
+// before any of its children.
 func Walk(node Node, f Func) {
-	if node == nil || reflect.ValueOf(node).IsNil() {
-		return
+	Inspect(node, func(n Node) bool {
+		if n != nil && f != nil {
+			f(n)
+		}
+		return true
+	})
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
 	}
+	return nil
+}
 
-	if f != nil {
-		f(node)
+// Inspect traverses an AST in depth-first order. It starts by calling
+// f(node); if f returns true, Inspect invokes f recursively for each of the
+// non-nil children of node, followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	WalkVisitor(inspector(f), node)
+}
+
+// WalkVisitor traverses an AST in depth-first order. It starts by calling
+// v.Visit(node); node must not be nil. If the visitor w returned by
+// v.Visit(node) is not nil, WalkVisitor is invoked recursively with visitor
+// w for each of the non-nil children of node, followed by a call of
+// w.Visit(nil).
+func WalkVisitor(v Visitor, node Node) {
+	if isNil(node) {
+		return
 	}
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
 	switch n := node.(type) {
 	case *AssignExpr:
-		Walk(n.Name, f)
-		Walk(n.Value, f)
+		WalkVisitor(v, n.Name)
+		WalkVisitor(v, n.Value)
 	case *BinaryExpr:
-		for _, v := range n.List {
-			Walk(v, f)
-		}
+		walkExprList(v, n.List)
 	case *CallExpr:
-		Walk(n.Name, f)
-		for _, v := range n.Args {
-			Walk(v, f)
+		WalkVisitor(v, n.Name)
+		walkExprList(v, n.Args)
+	case *CommentGroup:
+		for _, c := range n.List {
+			WalkVisitor(v, c)
 		}
 	case *DeclExpr:
-		Walk(n.Name, f)
-		for _, v := range n.Params {
-			Walk(v, f)
+		WalkVisitor(v, n.Doc)
+		WalkVisitor(v, n.Name)
+		for _, p := range n.Params {
+			WalkVisitor(v, p)
 		}
-		Walk(n.Type, f)
-		Walk(n.Body, f)
+		WalkVisitor(v, n.Type)
+		WalkVisitor(v, n.Body)
 	case *ExprList:
-		for _, v := range n.List {
-			Walk(v, f)
-		}
+		walkExprList(v, n.List)
 	case *File:
-		for _, v := range n.Scope.Table {
-			Walk(v.Value, f)
-		}
+		walkScope(v, n.Scope)
 	case *IfExpr:
-		Walk(n.Cond, f)
-		Walk(n.Type, f)
-		Walk(n.Then, f)
-		Walk(n.Else, f)
+		WalkVisitor(v, n.Cond)
+		WalkVisitor(v, n.Type)
+		WalkVisitor(v, n.Then)
+		WalkVisitor(v, n.Else)
+	case *ImportExpr:
+		WalkVisitor(v, n.Name)
+		WalkVisitor(v, n.Path)
 	case *Package:
-		for _, v := range n.Scope.Table {
-			Walk(v.Value, f)
+		walkScope(v, n.Scope)
+	case *UnaryExpr:
+		WalkVisitor(v, n.Value)
+	case *VarExpr:
+		WalkVisitor(v, n.Name)
+		if n.Object != nil {
+			WalkVisitor(v, n.Object.Type)
+			WalkVisitor(v, n.Object.Value)
 		}
+	}
+
+	v.Visit(nil)
+}
+
+func walkExprList(v Visitor, list []Expr) {
+	for _, e := range list {
+		WalkVisitor(v, e)
+	}
+}
+
+func walkScope(v Visitor, s *Scope) {
+	if s == nil {
+		return
+	}
+	for _, ob := range s.Objects() {
+		WalkVisitor(v, ob.Value)
+	}
+}
+
+// isNil reports whether node is nil or a nil pointer of a known node type
+func isNil(node Node) bool {
+	switch n := node.(type) {
+	case nil:
+		return true
+	case *AssignExpr:
+		return n == nil
+	case *BasicLit:
+		return n == nil
+	case *BinaryExpr:
+		return n == nil
+	case *CallExpr:
+		return n == nil
+	case *Comment:
+		return n == nil
+	case *CommentGroup:
+		return n == nil
+	case *DeclExpr:
+		return n == nil
+	case *ExprList:
+		return n == nil
+	case *File:
+		return n == nil
+	case *Ident:
+		return n == nil
+	case *IfExpr:
+		return n == nil
+	case *ImportExpr:
+		return n == nil
+	case *Package:
+		return n == nil
 	case *UnaryExpr:
-		Walk(n.Value, f)
+		return n == nil
 	case *VarExpr:
-		Walk(n.Name, f)
-		Walk(n.Object.Type, f)
-		Walk(n.Object.Value, f)
+		return n == nil
 	}
+	return false
 }
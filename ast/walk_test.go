@@ -0,0 +1,161 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package ast_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/rthornton128/calc/ast"
+	"github.com/rthornton128/calc/token"
+)
+
+func lit(s string) *ast.BasicLit {
+	return &ast.BasicLit{Kind: token.INTEGER, Lit: s}
+}
+
+func ident(s string) *ast.Ident {
+	return &ast.Ident{Name: s}
+}
+
+// (foo (+ 1 2) (- 3) x)
+func testTree() *ast.CallExpr {
+	return &ast.CallExpr{
+		Name: ident("foo"),
+		Args: []ast.Expr{
+			&ast.BinaryExpr{Op: token.ADD, List: []ast.Expr{lit("1"), lit("2")}},
+			&ast.UnaryExpr{Op: token.SUB, Value: lit("3")},
+			ident("x"),
+		},
+	}
+}
+
+// str returns a compact, S-expression like representation of n
+func str(n ast.Node) string {
+	var s []string
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch t := n.(type) {
+		case nil:
+			s = append(s, ")")
+		case *ast.BasicLit:
+			s = append(s, t.Lit)
+		case *ast.BinaryExpr:
+			s = append(s, "("+t.Op.String())
+		case *ast.CallExpr:
+			s = append(s, "(call")
+		case *ast.Ident:
+			s = append(s, t.Name)
+		case *ast.UnaryExpr:
+			s = append(s, "("+t.Op.String())
+		default:
+			s = append(s, fmt.Sprintf("(%T", n))
+		}
+		_, leaf := n.(*ast.BasicLit)
+		_, id := n.(*ast.Ident)
+		return !leaf && !id
+	})
+	return strings.Replace(strings.Join(s, " "), " )", ")", -1)
+}
+
+func TestInspect(t *testing.T) {
+	exp := "(call foo (+ 1 2) (- 3) x)"
+	if s := str(testTree()); s != exp {
+		t.Fatal("Expected:", exp, "Got:", s)
+	}
+
+	// stop descent into the binary expression
+	var count int
+	ast.Inspect(testTree(), func(n ast.Node) bool {
+		if n != nil {
+			count++
+		}
+		_, ok := n.(*ast.BinaryExpr)
+		return !ok
+	})
+	if count != 6 {
+		t.Fatal("Expected: 6 nodes Got:", count)
+	}
+}
+
+type counter map[string]int
+
+func (c counter) Visit(n ast.Node) ast.Visitor {
+	if n != nil {
+		c[fmt.Sprintf("%T", n)]++
+	}
+	return c
+}
+
+func TestWalkVisitor(t *testing.T) {
+	c := counter{}
+	ast.WalkVisitor(c, testTree())
+	if c["*ast.BasicLit"] != 3 || c["*ast.Ident"] != 2 || c["*ast.CallExpr"] != 1 {
+		t.Fatal("Unexpected counts:", c)
+	}
+}
+
+func TestApply(t *testing.T) {
+	var tests = []struct {
+		name string
+		pre  ast.ApplyFunc
+		exp  string
+	}{
+		{"replace", func(c *ast.Cursor) bool {
+			if b, ok := c.Node().(*ast.BasicLit); ok && b.Lit == "3" {
+				c.Replace(ident("y"))
+			}
+			return true
+		}, "(call foo (+ 1 2) (- y) x)"},
+		{"replace in list", func(c *ast.Cursor) bool {
+			if _, ok := c.Node().(*ast.BinaryExpr); ok {
+				c.Replace(lit("3"))
+				return false
+			}
+			return true
+		}, "(call foo 3 (- 3) x)"},
+		{"delete", func(c *ast.Cursor) bool {
+			switch n := c.Node().(type) {
+			case *ast.BasicLit:
+				if n.Lit == "1" {
+					c.Delete()
+				}
+			case *ast.UnaryExpr, *ast.Ident:
+				if c.Name() == "Args" {
+					c.Delete()
+				}
+			}
+			return true
+		}, "(call foo (+ 2))"},
+	}
+	for _, v := range tests {
+		res := ast.Apply(testTree(), v.pre, nil)
+		if s := str(res); s != v.exp {
+			t.Fatal(v.name, "- Expected:", v.exp, "Got:", s)
+		}
+	}
+
+	res := ast.Apply(testTree(), nil, func(c *ast.Cursor) bool {
+		if _, ok := c.Node().(*ast.CallExpr); ok {
+			c.Replace(lit("0"))
+		}
+		return true
+	})
+	if s := str(res); s != "0" {
+		t.Fatal("root - Expected: 0 Got:", s)
+	}
+
+	var visited int
+	ast.Apply(testTree(), nil, func(c *ast.Cursor) bool {
+		visited++
+		return c.Index() < 0
+	})
+	if visited != 2 {
+		t.Fatal("abort - Expected: 2 Got:", visited)
+	}
+}
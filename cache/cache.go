@@ -0,0 +1,138 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+// Package cache implements a content addressed store for build outputs
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ID identifies a set of build outputs. It is the hash of every input the
+// outputs were built from.
+type ID [sha256.Size]byte
+
+// String returns the ID in hexadecimal
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Hash computes an ID from a sequence of inputs
+type Hash struct {
+	h hash.Hash
+}
+
+// NewHash returns a new, empty Hash
+func NewHash() *Hash {
+	return &Hash{h: sha256.New()}
+}
+
+// Add adds each string in parts to the hash. Every part is prefixed by its
+// length so that, for example, adding "ab" and "c" does not produce the
+// same ID as adding "a" and "bc".
+func (h *Hash) Add(parts ...string) {
+	var n [8]byte
+	for _, s := range parts {
+		binary.LittleEndian.PutUint64(n[:], uint64(len(s)))
+		h.h.Write(n[:])
+		io.WriteString(h.h, s)
+	}
+}
+
+// Sum returns the ID of the inputs added so far
+func (h *Hash) Sum() ID {
+	var id ID
+	h.h.Sum(id[:0])
+	return id
+}
+
+// Cache is a directory of build outputs. Each entry holds one or more named
+// files and is identified by an ID.
+type Cache struct {
+	dir string
+}
+
+// DefaultDir returns the directory of the default cache. It is the value of
+// the CALCCACHE environment variable, if set, or a directory named calc in
+// the user's cache directory.
+func DefaultDir() (string, error) {
+	if dir := os.Getenv("CALCCACHE"); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "calc"), nil
+}
+
+// Open opens the cache in dir, creating the directory if it does not exist
+func Open(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Dir returns the directory holding the cache
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+func (c *Cache) entry(id ID) string {
+	s := id.String()
+	return filepath.Join(c.dir, s[:2], s)
+}
+
+// Get returns the path of the file called name in the entry identified by
+// id. Ok is false if there is no such file. The file must not be modified.
+func (c *Cache) Get(id ID, name string) (path string, ok bool) {
+	path = filepath.Join(c.entry(id), name)
+	if fi, err := os.Stat(path); err != nil || !fi.Mode().IsRegular() {
+		return "", false
+	}
+	return path, true
+}
+
+// Put stores a copy of file as name in the entry identified by id. The copy
+// is written to a temporary file and renamed into place so that a
+// concurrent Get never observes a partially written file.
+func (c *Cache) Put(id ID, name, file string) error {
+	dir := c.entry(id)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := ioutil.TempFile(dir, name+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, in)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package cache_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rthornton128/calc/cache"
+)
+
+func TestHash(t *testing.T) {
+	sum := func(parts ...string) cache.ID {
+		h := cache.NewHash()
+		h.Add(parts...)
+		return h.Sum()
+	}
+	if sum("a", "b") != sum("a", "b") {
+		t.Fatal("Expected: same inputs to produce the same ID")
+	}
+	if sum("ab", "c") == sum("a", "bc") {
+		t.Fatal("Expected: different inputs to produce different IDs")
+	}
+
+	h := cache.NewHash()
+	h.Add("a")
+	h.Add("b")
+	if h.Sum() != sum("a", "b") {
+		t.Fatal("Expected: parts added separately to produce the same ID")
+	}
+}
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := cache.Open(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := cache.NewHash()
+	h.Add("pkg")
+	id := h.Sum()
+
+	if _, ok := c.Get(id, "pkg.o"); ok {
+		t.Fatal("Expected: empty cache")
+	}
+
+	file := filepath.Join(dir, "pkg.o")
+	if err := ioutil.WriteFile(file, []byte("object"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(id, "pkg.o", file); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(file)
+
+	path, ok := c.Get(id, "pkg.o")
+	if !ok {
+		t.Fatal("Expected: pkg.o in cache")
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "object" {
+		t.Fatal("Expected: object Got:", string(data), err)
+	}
+	if _, ok := c.Get(id, "pkg.h"); ok {
+		t.Fatal("Expected: no pkg.h in cache")
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rthornton128/calc/ast"
+	"github.com/rthornton128/calc/cache"
+	"github.com/rthornton128/calc/comp"
+	"github.com/rthornton128/calc/load"
+	"github.com/rthornton128/calc/token"
+)
+
+// builder compiles each package of a program to an object and links the
+// objects into an executable. A package whose inputs are unchanged since
+// it was last built is not compiled again; its object is taken from the
+// build cache instead.
+type builder struct {
+	fset  *token.FileSet
+	cache *cache.Cache // nil if there is no build cache
+	force bool         // rebuild every package, ignoring the cache
+	trace bool         // print commands as they are executed
+
+	tc *toolchain
+	rt *runtimeLib // nil to build the runtime embedded in calcc
+
+	work string // temporary directory intermediate files are written to
+	keep bool   // do not remove the work directory
+}
+
+// buildFlags holds the command line flags controlling how programs are
+// built, which are shared by every command building one
+type buildFlags struct {
+	all, work, x            bool
+	cc, cflags, ld, ldflags string
+	runtime                 string
+}
+
+// register defines the build flags in fs
+func (f *buildFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&f.all, "a", false, "force rebuilding of packages that are "+
+		"already up-to-date")
+	fs.StringVar(&f.cc, "cc", getenv("CC", "gcc"), "C compiler to use")
+	fs.StringVar(&f.cflags, "cflags", os.Getenv("CFLAGS"), "C compiler flags, "+
+		"excluding -c and -o (default \"-g -std=gnu99\" for gcc and clang)")
+	fs.StringVar(&f.ld, "ld", "", "linker (default the C compiler)")
+	fs.StringVar(&f.ldflags, "ldflags", os.Getenv("LDFLAGS"), "linker flags")
+	fs.StringVar(&f.runtime, "runtime", "", "directory containing a prebuilt "+
+		"runtime library and headers to use instead of the embedded runtime")
+	fs.BoolVar(&f.work, "work", false, "print the name of the temporary work "+
+		"directory and do not delete it when exiting")
+	fs.BoolVar(&f.x, "x", false, "print the commands as they are executed")
+}
+
+// newBuilder returns a builder configured by the flags which adds files to
+// fset. Intermediate files are written to a new temporary work directory,
+// never to the source directory, which is removed by cleanup.
+func (f *buildFlags) newBuilder(fset *token.FileSet) (*builder, error) {
+	tc, err := newToolchain(f.cc, f.cflags, f.ld, f.ldflags)
+	if err != nil {
+		return nil, err
+	}
+	b := &builder{fset: fset, force: f.all, trace: f.x, tc: tc, keep: f.work}
+	if f.runtime != "" {
+		if b.rt, err = prebuiltRuntime(f.runtime); err != nil {
+			return nil, err
+		}
+	}
+	if dir, err := cache.DefaultDir(); err == nil {
+		b.cache, _ = cache.Open(dir)
+	}
+
+	if b.work, err = ioutil.TempDir("", "calc-build"); err != nil {
+		return nil, err
+	}
+	if f.work {
+		fmt.Fprintln(os.Stderr, "WORK="+b.work)
+	}
+	return b, nil
+}
+
+// cleanup removes the work directory unless it is to be kept
+func (b *builder) cleanup() {
+	if !b.keep {
+		os.RemoveAll(b.work)
+	}
+}
+
+// build compiles pkg and every package it imports, then links them into
+// the executable out. The generated files of the main package are named
+// after name.
+func (b *builder) build(pkg *load.Package, name, out string) error {
+	if b.rt == nil {
+		rt, err := b.embeddedRuntime()
+		if err != nil {
+			return err
+		}
+		b.rt = rt
+	}
+
+	ids := make(map[*load.Package]cache.ID)
+	var objs []string
+	for _, p := range load.Packages(pkg) {
+		ids[p] = b.packageID(p, ids)
+		base := name
+		if p.Path != "" {
+			base = comp.BaseName(p.Path)
+		}
+		obj, err := b.compile(p, filepath.Join(b.work, base), ids[p])
+		if err != nil {
+			return err
+		}
+		objs = append(objs, obj)
+	}
+
+	objs = append(objs, b.rt.objs...)
+	return b.run(b.tc.linkCmd(out, objs...))
+}
+
+// compile generates C source code for the package p as base.c, and base.h
+// if p is not the main package, and compiles it to base.o. The path of the
+// object is returned, which is in the build cache if p is unchanged.
+func (b *builder) compile(p *load.Package, base string, id cache.ID) (string,
+	error) {
+	main := p.Path == ""
+	if obj, ok := b.cached(id, base, main); ok {
+		return obj, nil
+	}
+
+	src, err := os.Create(base + ".c")
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	var hdr io.Writer
+	if !main {
+		fp, err := os.Create(base + ".h")
+		if err != nil {
+			return "", err
+		}
+		defer fp.Close()
+		hdr = fp
+	}
+
+	if err := comp.CompilePackage(src, hdr, b.fset, p); err != nil {
+		return "", err
+	}
+
+	cmd := b.tc.compileCmd(base+".o", base+".c", b.rt.include)
+	if err := b.run(cmd); err != nil {
+		return "", err
+	}
+
+	if b.cache != nil {
+		// failing to cache an object does not fail the build
+		b.cache.Put(id, "pkg.o", base+".o")
+		if !main {
+			b.cache.Put(id, "pkg.h", base+".h")
+		}
+	}
+	return base + ".o", nil
+}
+
+// cached returns the cached object for the package identified by id. The
+// cached header of any package but the main package is copied to base.h
+// for the packages importing it.
+func (b *builder) cached(id cache.ID, base string, main bool) (string, bool) {
+	if b.cache == nil || b.force {
+		return "", false
+	}
+	obj, ok := b.cache.Get(id, "pkg.o")
+	if !ok || main {
+		return obj, ok
+	}
+
+	hdr, ok := b.cache.Get(id, "pkg.h")
+	if !ok {
+		return "", false
+	}
+	data, err := ioutil.ReadFile(hdr)
+	if err == nil {
+		err = ioutil.WriteFile(base+".h", data, 0644)
+	}
+	return obj, err == nil
+}
+
+// packageID returns the cache ID of package p. The ID covers the compiler
+// version and flags, the runtime headers, the import path and sources of p
+// and the IDs of the packages it imports, which must already be in ids.
+// The main package of a test program is distinguished from the same
+// package built as a program since its main function differs. The
+// location of the runtime headers is left out since the embedded runtime
+// moves into the cache once it has been built.
+func (b *builder) packageID(p *load.Package,
+	ids map[*load.Package]cache.ID) cache.ID {
+	h := cache.NewHash()
+	h.Add(version, b.tc.version, b.rt.hash, p.Path)
+	if p.Test {
+		h.Add("test")
+	}
+	h.Add(b.tc.compileCmd("", "")...)
+	for _, f := range p.Files {
+		h.Add(b.fset.File(f.Pos()).Name(), b.fset.Text(ast.RangeOf(f)))
+	}
+	for _, imp := range p.Imports {
+		h.Add(ids[imp].String())
+	}
+	return h.Sum()
+}
+
+// run executes the command cmd, the name of a program followed by its
+// arguments, printing it first if tracing. The error returned if cmd fails
+// includes the command and its output.
+func (b *builder) run(cmd []string) error {
+	if b.trace {
+		fmt.Fprintln(os.Stderr, quoteArgs(cmd))
+	}
+	out, err := exec.Command(cmd[0], cmd[1:]...).CombinedOutput()
+	if err != nil {
+		msg := fmt.Sprintf("command failed: %s: %v", quoteArgs(cmd), err)
+		if out := strings.TrimSpace(string(out)); out != "" {
+			msg += "\n" + out
+		}
+		return errors.New(msg)
+	}
+	return nil
+}
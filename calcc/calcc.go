@@ -11,17 +11,26 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
-	"strings"
 
+	"github.com/rthornton128/calc/ast"
 	"github.com/rthornton128/calc/comp"
+	"github.com/rthornton128/calc/load"
+	"github.com/rthornton128/calc/parse"
+	"github.com/rthornton128/calc/repl"
+	"github.com/rthornton128/calc/token"
 )
 
-func cleanup(filename string) {
-	os.Remove(filename + ".c")
-	os.Remove(filename + ".o")
+const version = "2.0"
+
+// exeExt is the file name extension of executables
+var exeExt = ""
+
+func init() {
+	if runtime.GOOS == "windows" {
+		exeExt = ".exe"
+	}
 }
 
 func fatal(args ...interface{}) {
@@ -29,60 +38,81 @@ func fatal(args ...interface{}) {
 	os.Exit(1)
 }
 
-func findRuntime() string {
-	var paths []string
-	rpath := "/src/github.com/rthornton128/calc/runtime"
-	if runtime.GOOS == "Windows" {
-		paths = strings.Split(os.Getenv("GOPATH"), ";")
-	} else {
-		paths = strings.Split(os.Getenv("GOPATH"), ":")
+// dumpAST prints the syntax tree of the file or directory path, or of the
+// source read from standard input if path is "-"
+func dumpAST(path string, format string) error {
+	formats := map[string]ast.Format{
+		"tree":  ast.TreeFormat,
+		"json":  ast.JSONFormat,
+		"sexpr": ast.SExprFormat,
 	}
-	for _, path := range paths {
-		path = filepath.Join(path, rpath)
-		_, err := os.Stat(filepath.Join(path, "runtime.a"))
-		if err == nil || os.IsExist(err) {
-			return path
-		}
+	f, ok := formats[format]
+	if !ok {
+		return fmt.Errorf("unknown AST dump format '%s', must be one of "+
+			"tree, json or sexpr", format)
 	}
-	return ""
+
+	var node ast.Node
+	fset := token.NewFileSet()
+	fi, err := os.Stat(path)
+	switch {
+	case path == "-":
+		node, err = parse.ParseReader(fset, "stdin", os.Stdin, nil)
+	case err != nil:
+	case fi.IsDir():
+		node, err = parse.ParseDir(fset, path)
+	default:
+		node, err = parse.ParseFile(fset, path, nil)
+	}
+	if err != nil {
+		return err
+	}
+	return ast.Fprint(os.Stdout, fset, node, f)
 }
 
-func make_args(options ...string) string {
-	var args string
-	for i, opt := range options {
-		if len(opt) > 0 {
-			args += opt
-			if i < len(options)-1 {
-				args += " "
-			}
-		}
+// getenv returns the value of the environment variable key or def if it is
+// not set
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
 	}
-	return args
+	return def
 }
 
 func printVersion() {
-	fmt.Fprintln(os.Stderr, "Calc Compiler Tool Version 2.0")
+	fmt.Fprintln(os.Stderr, "Calc Compiler Tool Version", version)
 }
 
 func main() {
-	ext := ""
-	if runtime.GOOS == "windows" {
-		ext = ".exe"
+	if len(os.Args) > 1 && os.Args[1] == "repl" {
+		if err := repl.New(os.Stdout).Run(os.Stdin); err != nil {
+			fatal(err)
+		}
+		return
 	}
+	if len(os.Args) > 1 && os.Args[1] == "test" {
+		testMain(os.Args[2:])
+		return
+	}
+
 	flag.Usage = func() {
 		printVersion()
 		fmt.Fprintln(os.Stderr, "\nUsage of:", os.Args[0])
 		fmt.Fprintln(os.Stderr, os.Args[0], "[flags] <filename>")
+		fmt.Fprintln(os.Stderr, os.Args[0], "repl")
+		fmt.Fprintln(os.Stderr, os.Args[0], "test [flags] [directory]")
 		flag.PrintDefaults()
 	}
+	var bf buildFlags
+	bf.register(flag.CommandLine)
 	var (
 		asm  = flag.Bool("s", false, "generate C code but do not compile")
-		cc   = flag.String("cc", "gcc", "C compiler to use")
-		cfl  = flag.String("cflags", "-c -g -std=gnu99", "C compiler flags")
-		cout = flag.String("cout", "--output=", "C compiler output flag")
-		ld   = flag.String("ld", "gcc", "linker")
-		ldf  = flag.String("ldflags", "", "linker flags")
-		ver  = flag.Bool("v", false, "Print version number and exit")
+		dump = flag.String("dump-ast", "", "print the syntax tree in the "+
+			"given format (tree, json or sexpr) and exit; a filename of - "+
+			"reads the source from standard input")
+		out = flag.String("o", "", "write the executable to the named file "+
+			"or directory, or with -s the C source files to the named directory")
+		ver = flag.Bool("v", false, "Print version number and exit")
 	)
 	flag.Parse()
 
@@ -101,12 +131,14 @@ func main() {
 		os.Exit(1)
 	}
 
-	/* do a preemptive search to see if runtime can be found. Does not
-	 * guarantee it will be there at link time */
-	rpath := findRuntime()
-	if rpath == "" {
-		fatal("Unable to find runtime in GOPATH. Be sure 'make' command was " +
-			"run in source directory")
+	if *dump != "" {
+		if flag.Arg(0) == "-" {
+			path = "-"
+		}
+		if err := dumpAST(path, *dump); err != nil {
+			fatal(err)
+		}
+		return
 	}
 
 	fi, err := os.Stat(path)
@@ -114,38 +146,47 @@ func main() {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	dir, name := path, filepath.Base(path)
+	if !fi.IsDir() {
+		dir = filepath.Dir(path)
+		name = name[:len(name)-len(filepath.Ext(name))]
+	}
+
+	/* each package is compiled to its own C source file and object */
+	fset := token.NewFileSet()
+	l := load.New(fset, load.SearchPath(dir))
+	var pkg *load.Package
 	if fi.IsDir() {
-		err = comp.CompileDir(path)
-		path = filepath.Join(path, filepath.Base(path))
+		pkg, err = l.LoadDir(path)
 	} else {
-		err = comp.CompileFile(path)
+		pkg, err = l.LoadFile(path)
 	}
-
-	path = path[:len(path)-len(filepath.Ext(path))]
 	if err != nil {
 		fmt.Println(err)
-		cleanup(path)
 		os.Exit(1)
 	}
-	if !*asm {
-		/* compile to object code */
-		var out []byte
-		args := make_args(*cfl, "-I "+rpath, *cout+path+".o", path+".c")
-		out, err := exec.Command(*cc+ext,
-			strings.Split(args, " ")...).CombinedOutput()
-		if err != nil {
-			cleanup(path)
-			fatal(string(out), err)
+	if *asm {
+		if *out == "" {
+			*out = "."
 		}
-
-		/* link to executable */
-		args = make_args(*ldf, *cout+path+ext, path+".o", rpath+"/runtime.a")
-		out, err = exec.Command(*ld+ext,
-			strings.Split(args, " ")...).CombinedOutput()
-		if err != nil {
-			cleanup(path)
-			fatal(string(out), err)
+		if _, err := comp.CompileProgram(*out, name, fset, pkg); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
-		cleanup(path)
+		return
+	}
+
+	b, err := bf.newBuilder(fset)
+	if err != nil {
+		fatal(err)
+	}
+	exe := *out
+	if fi, err := os.Stat(exe); exe == "" || err == nil && fi.IsDir() {
+		exe = filepath.Join(exe, name+exeExt)
+	}
+	err = b.build(pkg, name, exe)
+	b.cleanup()
+	if err != nil {
+		fatal(err)
 	}
 }
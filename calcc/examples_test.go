@@ -0,0 +1,193 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rthornton128/calc/cache"
+	"github.com/rthornton128/calc/load"
+	"github.com/rthornton128/calc/parse"
+	"github.com/rthornton128/calc/token"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+const examples = "../examples"
+
+// example is a program, or the tests of a package, in the examples
+// directory
+type example struct {
+	path string // relative to the examples directory
+	test bool   // run the tests of the package in path
+}
+
+// golden returns the name of the golden file holding the expected result
+func (e example) golden() string {
+	name := strings.TrimSuffix(filepath.ToSlash(e.path), ".calc")
+	name = strings.Replace(name, "/", "_", -1)
+	if e.test {
+		name += ".test"
+	}
+	return filepath.Join("testdata", name+".golden")
+}
+
+// findExamples returns every program in the examples directory: each
+// source file and each directory, which holds a program split into
+// packages. Any package with test files is also an example, of its tests.
+func findExamples() ([]example, error) {
+	var list []example
+	err := filepath.Walk(examples, func(path string, fi os.FileInfo,
+		err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(examples, path)
+		depth := len(strings.Split(rel, string(filepath.Separator)))
+		switch {
+		case rel == ".":
+		case fi.IsDir() && depth == 1:
+			list = append(list, example{path: rel})
+		case fi.IsDir():
+		case parse.IsTestFile(rel):
+			// only packages, not the examples directory itself, have tests
+			dir := filepath.Dir(rel)
+			n := len(list)
+			if depth > 1 && (n == 0 || list[n-1] != example{dir, true}) {
+				list = append(list, example{path: dir, test: true})
+			}
+		case filepath.Ext(rel) == ".calc" && depth == 1:
+			list = append(list, example{path: rel})
+		}
+		return nil
+	})
+	return list, err
+}
+
+// TestExamples builds and runs every example and compares the result with
+// its golden file: the output of the program followed by its exit status,
+// or the errors reported by calcc if it failed to build. Tests are run
+// verbosely so that each is listed. Run with -update to write the golden
+// files instead.
+func TestExamples(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping examples in short mode")
+	}
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tc, err := newToolchain("gcc", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := cache.Open(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := findExamples()
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := make(map[string]bool)
+	for _, e := range list {
+		golden[e.golden()] = true
+		b := &builder{cache: c, tc: tc, work: filepath.Join(dir, "work")}
+		got := runExample(t, b, e)
+
+		if *update {
+			if err := ioutil.WriteFile(e.golden(), []byte(got), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		exp, err := ioutil.ReadFile(e.golden())
+		if err != nil {
+			t.Errorf("%s: %v (run with -update to create it)", e.path, err)
+			continue
+		}
+		if got != string(exp) {
+			t.Errorf("%s:\nExpected:\n%sGot:\n%s", e.path, exp, got)
+		}
+	}
+
+	names, _ := filepath.Glob(filepath.Join("testdata", "*.golden"))
+	sort.Strings(names)
+	for _, name := range names {
+		if !golden[name] {
+			t.Errorf("%s: no example uses this golden file", name)
+		}
+	}
+}
+
+// runExample builds the example e in the work directory of b, then runs it
+// and returns the result to compare with its golden file. File names in
+// errors are made relative to the examples directory.
+func runExample(t *testing.T, b *builder, e example) string {
+	if err := os.RemoveAll(b.work); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(b.work, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(examples, e.path)
+	b.fset = token.NewFileSet()
+	var pkg *load.Package
+	var err error
+	switch {
+	case e.test:
+		pkg, err = load.New(b.fset, load.SearchPath(path)).LoadTest(path)
+	case filepath.Ext(path) == ".calc":
+		pkg, err = load.New(b.fset, load.SearchPath(examples)).LoadFile(path)
+	default:
+		pkg, err = load.New(b.fset, load.SearchPath(path)).LoadDir(path)
+	}
+
+	exe := filepath.Join(b.work, "example"+exeExt)
+	if err == nil {
+		err = b.build(pkg, "example", exe)
+	}
+	if err != nil {
+		msg := strings.Replace(err.Error(), examples+string(filepath.Separator),
+			"", -1)
+		return filepath.ToSlash(msg) + "build failed\n"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, exe)
+	if e.test {
+		cmd.Args = append(cmd.Args, "-v")
+	}
+	out, err := cmd.CombinedOutput()
+	status := 0
+	if err != nil {
+		ee, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatal(e.path, err)
+		}
+		status = ee.ExitCode()
+	}
+	return fmt.Sprintf("%sexit status %d\n", out, status)
+}
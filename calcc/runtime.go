@@ -0,0 +1,151 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rthornton128/calc"
+	"github.com/rthornton128/calc/cache"
+)
+
+// runtimeLib is the C runtime library every program is linked against
+type runtimeLib struct {
+	include string   // directory containing the runtime headers
+	objs    []string // objects or libraries to link
+	hash    string   // hash of the headers, included by every package
+}
+
+// prebuiltRuntime returns the runtime library and headers in dir, as built
+// by make
+func prebuiltRuntime(dir string) (*runtimeLib, error) {
+	lib := filepath.Join(dir, "runtime.a")
+	if _, err := os.Stat(lib); err != nil {
+		return nil, fmt.Errorf("no runtime in %s: %v", dir, err)
+	}
+	hash, err := headerHash(os.DirFS(dir))
+	if err != nil {
+		return nil, err
+	}
+	return &runtimeLib{include: dir, objs: []string{lib}, hash: hash}, nil
+}
+
+// embeddedRuntime returns the runtime built from the sources embedded in
+// calcc. The sources are compiled with the builder's toolchain the first
+// time they are needed and the objects and headers are kept in the build
+// cache, so later builds link the cached objects directly.
+func (b *builder) embeddedRuntime() (*runtimeLib, error) {
+	hash, err := headerHash(calc.Runtime)
+	if err != nil {
+		return nil, err
+	}
+	names, err := fs.Glob(calc.Runtime, "*.[ch]")
+	if err != nil {
+		return nil, err
+	}
+
+	h := cache.NewHash()
+	h.Add(version, b.tc.version)
+	h.Add(b.tc.compileCmd("", "")...)
+	files := make(map[string][]byte)
+	for _, name := range names {
+		if files[name], err = fs.ReadFile(calc.Runtime, name); err != nil {
+			return nil, err
+		}
+		h.Add(name, string(files[name]))
+	}
+	id := h.Sum()
+
+	/* the objects and headers of the runtime are stored in one entry */
+	var outs []string
+	for _, name := range names {
+		if strings.HasSuffix(name, ".c") {
+			name = strings.TrimSuffix(name, ".c") + ".o"
+		}
+		outs = append(outs, name)
+	}
+	if rt, ok := b.cachedRuntime(id, outs, hash); ok {
+		return rt, nil
+	}
+
+	dir := filepath.Join(b.work, "runtime")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	rt := &runtimeLib{include: dir, hash: hash}
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), files[name],
+			0644); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".c") {
+			continue
+		}
+		src := filepath.Join(dir, name)
+		obj := strings.TrimSuffix(src, ".c") + ".o"
+		if err := b.run(b.tc.compileCmd(obj, src, dir)); err != nil {
+			return nil, err
+		}
+		rt.objs = append(rt.objs, obj)
+	}
+
+	if b.cache != nil {
+		// failing to cache the runtime does not fail the build
+		for _, name := range outs {
+			b.cache.Put(id, name, filepath.Join(dir, name))
+		}
+	}
+	return rt, nil
+}
+
+// cachedRuntime returns the runtime stored in the cache entry id, which must
+// hold every file in names
+func (b *builder) cachedRuntime(id cache.ID, names []string,
+	hash string) (*runtimeLib, bool) {
+	if b.cache == nil || b.force {
+		return nil, false
+	}
+	rt := &runtimeLib{hash: hash}
+	for _, name := range names {
+		path, ok := b.cache.Get(id, name)
+		if !ok {
+			return nil, false
+		}
+		rt.include = filepath.Dir(path)
+		if strings.HasSuffix(name, ".o") {
+			rt.objs = append(rt.objs, path)
+		}
+	}
+	return rt, true
+}
+
+// headerHash returns a hash of the runtime headers in fsys, which are
+// included by every generated C source file
+func headerHash(fsys fs.FS) (string, error) {
+	names, err := fs.Glob(fsys, "*.h")
+	if err != nil {
+		return "", err
+	}
+
+	h := cache.NewHash()
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return "", err
+		}
+		h.Add(name, string(data))
+	}
+	return h.Sum().String(), nil
+}
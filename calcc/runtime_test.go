@@ -0,0 +1,73 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package main
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rthornton128/calc"
+	"github.com/rthornton128/calc/cache"
+)
+
+func TestEmbeddedRuntime(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	dir, err := ioutil.TempDir("", "calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tc, err := newToolchain("gcc", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := cache.Open(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &builder{cache: c, tc: tc, work: filepath.Join(dir, "work")}
+
+	rt, err := b.embeddedRuntime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcs, _ := fs.Glob(calc.Runtime, "*.c")
+	if len(srcs) == 0 || len(rt.objs) != len(srcs) {
+		t.Fatal("Expected: an object for each of", srcs, "Got:", rt.objs)
+	}
+	for _, obj := range rt.objs {
+		if !strings.HasPrefix(obj, b.work) {
+			t.Fatal("Expected: object built in work directory Got:", obj)
+		}
+	}
+
+	os.RemoveAll(b.work)
+	cached, err := b.embeddedRuntime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.hash != rt.hash {
+		t.Fatal("Expected: same header hash for cached runtime")
+	}
+	if _, err := os.Stat(filepath.Join(cached.include, "runtime.h")); err != nil {
+		t.Fatal("Expected: runtime.h in cache Got:", err)
+	}
+	for _, obj := range cached.objs {
+		if !strings.HasPrefix(obj, c.Dir()) {
+			t.Fatal("Expected: object from cache Got:", obj)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/rthornton128/calc/load"
+	"github.com/rthornton128/calc/token"
+)
+
+// testMain implements the test command. The package in the directory named
+// by args, along with its test files, is built into a program which runs
+// each test and reports those that fail. The program is then run and the
+// command exits with a non-zero status if any test failed.
+func testMain(args []string) {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of:", os.Args[0], "test")
+		fmt.Fprintln(os.Stderr, os.Args[0], "test [flags] [directory]")
+		fs.PrintDefaults()
+	}
+	var bf buildFlags
+	bf.register(fs)
+	verbose := fs.Bool("v", false, "print the name and result of every test")
+	fs.Parse(args)
+
+	var dir string
+	switch fs.NArg() {
+	case 0:
+		dir, _ = filepath.Abs(".")
+	case 1:
+		dir, _ = filepath.Abs(fs.Arg(0))
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	fset := token.NewFileSet()
+	pkg, err := load.New(fset, load.SearchPath(dir)).LoadTest(dir)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	b, err := bf.newBuilder(fset)
+	if err != nil {
+		fatal(err)
+	}
+	name := filepath.Base(dir)
+	exe := filepath.Join(b.work, name+".test"+exeExt)
+	if err := b.build(pkg, name, exe); err != nil {
+		b.cleanup()
+		fatal(err)
+	}
+
+	cmd := exec.Command(exe)
+	if *verbose {
+		cmd.Args = append(cmd.Args, "-v")
+	}
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if bf.x {
+		fmt.Fprintln(os.Stderr, quoteArgs(cmd.Args))
+	}
+	err = cmd.Run()
+	b.cleanup()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Kinds of C compilers, which differ in the flags they accept
+const (
+	gccKind   = "gcc"
+	clangKind = "clang"
+	msvcKind  = "msvc"
+	ccKind    = "cc" // unrecognized, assumed to accept the same flags as gcc
+)
+
+// toolchain describes how to invoke the C compiler and linker
+type toolchain struct {
+	kind    string
+	version string   // version information reported by the compiler
+	cc      []string // compiler command followed by any leading arguments
+	cflags  []string
+	ld      []string // linker command followed by any leading arguments
+	ldflags []string
+}
+
+// newToolchain parses the commands and flags, each a list of shell words as
+// accepted by splitArgs, and runs the compiler to identify its kind. If
+// cflags is empty the default flags for that kind are used. If ld is empty
+// the compiler is used to link.
+func newToolchain(cc, cflags, ld, ldflags string) (*toolchain, error) {
+	var t toolchain
+	var err error
+	if t.cc, err = splitArgs(cc); err != nil {
+		return nil, fmt.Errorf("invalid C compiler %q: %v", cc, err)
+	}
+	if len(t.cc) == 0 {
+		return nil, fmt.Errorf("no C compiler given")
+	}
+	if t.cflags, err = splitArgs(cflags); err != nil {
+		return nil, fmt.Errorf("invalid C compiler flags %q: %v", cflags, err)
+	}
+	if t.ld, err = splitArgs(ld); err != nil {
+		return nil, fmt.Errorf("invalid linker %q: %v", ld, err)
+	}
+	if len(t.ld) == 0 {
+		t.ld = t.cc
+	}
+	if t.ldflags, err = splitArgs(ldflags); err != nil {
+		return nil, fmt.Errorf("invalid linker flags %q: %v", ldflags, err)
+	}
+
+	if err := t.identify(); err != nil {
+		return nil, err
+	}
+	if cflags == "" {
+		t.cflags = []string{"-g", "-std=gnu99"}
+		if t.kind == msvcKind {
+			t.cflags = []string{"/nologo"}
+		}
+	}
+	return &t, nil
+}
+
+// identify determines the kind and version of the C compiler
+func (t *toolchain) identify() error {
+	name := strings.ToLower(filepath.Base(t.cc[len(t.cc)-1]))
+	if name == "cl" || name == "cl.exe" {
+		// cl has no version flag but prints its version when run without
+		// any input files, along with an error
+		out, _ := exec.Command(t.cc[0], t.cc[1:]...).CombinedOutput()
+		t.kind, t.version = msvcKind, firstLine(string(out))
+		return nil
+	}
+
+	args := append(t.cc[1:len(t.cc):len(t.cc)], "--version")
+	out, err := exec.Command(t.cc[0], args...).CombinedOutput()
+	if err != nil {
+		msg := fmt.Sprintf("unable to run C compiler %s: %v",
+			quoteArgs(append(t.cc[:len(t.cc):len(t.cc)], "--version")), err)
+		if out := strings.TrimSpace(string(out)); out != "" {
+			msg += "\n" + out
+		}
+		return errors.New(msg)
+	}
+	t.version = firstLine(string(out))
+	switch s := string(out); {
+	case strings.Contains(s, "clang"):
+		t.kind = clangKind
+	case strings.Contains(s, "gcc") || strings.Contains(s, "GCC") ||
+		strings.Contains(s, "Free Software Foundation"):
+		t.kind = gccKind
+	default:
+		t.kind = ccKind
+	}
+	return nil
+}
+
+// compileCmd returns the command compiling the C source file src to the
+// object obj, searching the include directories for headers
+func (t *toolchain) compileCmd(obj, src string, include ...string) []string {
+	cmd := append([]string{}, t.cc...)
+	cmd = append(cmd, t.cflags...)
+	for _, dir := range include {
+		if t.kind == msvcKind {
+			cmd = append(cmd, "/I"+dir)
+		} else {
+			cmd = append(cmd, "-I", dir)
+		}
+	}
+	if t.kind == msvcKind {
+		return append(cmd, "/c", "/Fo"+obj, src)
+	}
+	return append(cmd, "-c", "-o", obj, src)
+}
+
+// linkCmd returns the command linking the object files and libraries in
+// objs into the executable exe
+func (t *toolchain) linkCmd(exe string, objs ...string) []string {
+	cmd := append([]string{}, t.ld...)
+	cmd = append(cmd, t.ldflags...)
+	if t.kind == msvcKind {
+		cmd = append(cmd, "/Fe"+exe)
+	} else {
+		cmd = append(cmd, "-o", exe)
+	}
+	return append(cmd, objs...)
+}
+
+// splitArgs splits s into words separated by white space, like a shell. A
+// word, or part of one, may be quoted with single or double quotes so that
+// it may contain white space. There are no escape sequences, which leaves
+// Windows paths containing backslashes intact.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; ch {
+		case ' ', '\t', '\n', '\r':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case '\'', '"':
+			end := strings.IndexByte(s[i+1:], ch)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated %c string", ch)
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		default:
+			word.WriteByte(ch)
+			inWord = true
+		}
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+// quoteArgs joins args into a single string which splitArgs would split
+// into args again
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		switch {
+		case arg == "":
+			quoted[i] = "''"
+		case !strings.ContainsAny(arg, " \t\n\r'\""):
+			quoted[i] = arg
+		case !strings.Contains(arg, "'"):
+			quoted[i] = "'" + arg + "'"
+		default:
+			quoted[i] = `"` + strings.Replace(arg, `"`, `"'"'"`, -1) + `"`
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		s    string
+		args []string
+	}{
+		{"", nil},
+		{"  -g\t-O2 \n", []string{"-g", "-O2"}},
+		{`-I "/my dir" -DMSG='a b'`, []string{"-I", "/my dir", "-DMSG=a b"}},
+		{`-D'X="y"' ''`, []string{`-DX="y"`, ""}},
+		{`C:\Program Files\gcc.exe`, []string{`C:\Program`, `Files\gcc.exe`}},
+	}
+	for i, test := range tests {
+		args, err := splitArgs(test.s)
+		if err != nil || !reflect.DeepEqual(args, test.args) {
+			t.Fatalf("%d - Expected: %q Got: %q %v", i, test.args, args, err)
+		}
+		if args, _ := splitArgs(quoteArgs(test.args)); !reflect.DeepEqual(args,
+			test.args) {
+			t.Fatalf("%d - Expected: %q after quoting Got: %q", i, test.args, args)
+		}
+	}
+
+	if _, err := splitArgs(`-D"X`); err == nil ||
+		!strings.Contains(err.Error(), "unterminated") {
+		t.Fatal("Expected: unterminated string error Got:", err)
+	}
+	args := []string{`a'b"c`, "it's"}
+	if q, _ := splitArgs(quoteArgs(args)); !reflect.DeepEqual(q, args) {
+		t.Fatalf("Expected: %q Got: %q from %s", args, q, quoteArgs(args))
+	}
+}
+
+func TestToolchainCommands(t *testing.T) {
+	tc := &toolchain{kind: gccKind, cc: []string{"ccache", "gcc"},
+		cflags: []string{"-g"}, ld: []string{"gcc"}, ldflags: []string{"-s"}}
+	cmd := quoteArgs(tc.compileCmd("/w/a.o", "/w/a.c", "/my runtime"))
+	if exp := "ccache gcc -g -I '/my runtime' -c -o /w/a.o /w/a.c"; cmd != exp {
+		t.Fatal("Expected:", exp, "Got:", cmd)
+	}
+	cmd = quoteArgs(tc.linkCmd("out", "a.o", "b.o"))
+	if exp := "gcc -s -o out a.o b.o"; cmd != exp {
+		t.Fatal("Expected:", exp, "Got:", cmd)
+	}
+
+	tc = &toolchain{kind: msvcKind, cc: []string{"cl"}, ld: []string{"cl"}}
+	cmd = quoteArgs(tc.compileCmd("a.obj", "a.c", "rt"))
+	if exp := "cl /Irt /c /Foa.obj a.c"; cmd != exp {
+		t.Fatal("Expected:", exp, "Got:", cmd)
+	}
+	cmd = quoteArgs(tc.linkCmd("a.exe", "a.obj"))
+	if exp := "cl /Fea.exe a.obj"; cmd != exp {
+		t.Fatal("Expected:", exp, "Got:", cmd)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rthornton128/calc/ast"
+	"github.com/rthornton128/calc/doc"
+	"github.com/rthornton128/calc/parse"
+	"github.com/rthornton128/calc/token"
+)
+
+func fatal(args ...interface{}) {
+	fmt.Fprintln(os.Stderr, args...)
+	os.Exit(1)
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of:", os.Args[0])
+		fmt.Fprintln(os.Stderr, os.Args[0], "[flags] <filename or directory>")
+		flag.PrintDefaults()
+	}
+	var (
+		html = flag.Bool("html", false, "generate HTML instead of Markdown")
+		out  = flag.String("o", "", "write documentation to file instead of "+
+			"standard output")
+	)
+	flag.Parse()
+
+	var path string
+	switch flag.NArg() {
+	case 0:
+		path, _ = filepath.Abs(".")
+	case 1:
+		path, _ = filepath.Abs(flag.Arg(0))
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	p, err := load(path)
+	if err != nil {
+		fatal(err)
+	}
+	if err := write(*out, p, *html); err != nil {
+		fatal(err)
+	}
+}
+
+// load parses the file or directory path and collects its documentation
+func load(path string) (*doc.Package, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var scope *ast.Scope
+	fset := token.NewFileSet()
+	name := filepath.Base(path)
+	if fi.IsDir() {
+		var pkg *ast.Package
+		pkg, err = parse.ParseDir(fset, path)
+		if pkg != nil {
+			scope = pkg.Scope
+		}
+	} else {
+		var f *ast.File
+		f, err = parse.ParseFile(fset, path, nil)
+		if f != nil {
+			scope = f.Scope
+		}
+		name = name[:len(name)-len(filepath.Ext(name))]
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.New(fset, name, scope), nil
+}
+
+// write renders p to the named file, or to standard output if name is
+// empty
+func write(name string, p *doc.Package, html bool) error {
+	if name == "" {
+		return render(os.Stdout, p, html)
+	}
+	fp, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	return renderClose(fp, p, html)
+}
+
+// renderClose renders p to wc and closes it so that a failed write is
+// reported rather than lost. The first error is returned.
+func renderClose(wc io.WriteCloser, p *doc.Package, html bool) error {
+	err := render(wc, p, html)
+	if cerr := wc.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// render writes p to w as HTML or Markdown
+func render(w io.Writer, p *doc.Package, html bool) error {
+	if html {
+		return p.HTML(w)
+	}
+	return p.Markdown(w)
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	p, err := load(filepath.Join("..", "examples", "import", "shapes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "calcdoc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		html bool
+		exp  string
+	}{
+		{false, "# shapes\n\n## Area\n\n    (decl Area (w h int) int)\n\n" +
+			"Area returns the area of a w by h rectangle\n"},
+		{true, "<h1>shapes</h1>\n\n<h2 id=\"Area\">Area</h2>\n" +
+			"<pre>(decl Area (w h int) int)</pre>\n" +
+			"<p>Area returns the area of a w by h rectangle</p>\n"},
+	} {
+		name := filepath.Join(dir, "shapes.doc")
+		if err := write(name, p, test.html); err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(out), test.exp) {
+			t.Fatalf("Expected: %q Got: %q", test.exp, out)
+		}
+	}
+
+	if err := write(filepath.Join(dir, "missing", "shapes.md"), p,
+		false); err == nil {
+		t.Fatal("Expected: error creating file in missing directory")
+	}
+}
+
+// closer is a writer whose Close fails with err
+type closer struct {
+	strings.Builder
+	err    error
+	closed bool
+}
+
+func (c *closer) Close() error {
+	c.closed = true
+	return c.err
+}
+
+func TestRenderCloseError(t *testing.T) {
+	p, err := load(filepath.Join("..", "examples", "package", "add.calc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &closer{err: errors.New("close failed")}
+	if err := renderClose(c, p, false); err != c.err {
+		t.Fatal("Expected:", c.err, "Got:", err)
+	}
+	if !c.closed || !strings.Contains(c.String(), "## add") {
+		t.Fatalf("Expected: docs written and closed Got: %v %q", c.closed,
+			c.String())
+	}
+
+	c = &closer{}
+	if err := renderClose(c, p, true); err != nil || !c.closed {
+		t.Fatal("Expected: closed without error Got:", c.closed, err)
+	}
+}
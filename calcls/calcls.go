@@ -0,0 +1,38 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+// Command calcls is a Language Server Protocol server for Calc. It
+// communicates with the editor over standard input and output.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func fatal(args ...interface{}) {
+	fmt.Fprintln(os.Stderr, args...)
+	os.Exit(1)
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of:", os.Args[0])
+		fmt.Fprintln(os.Stderr, os.Args[0], "communicates over stdin and stdout")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	s := newServer(os.Stdin, os.Stdout)
+	if err := s.serve(); err != nil {
+		fatal(err)
+	}
+	if !s.shutdown {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,451 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package main
+
+import (
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/rthornton128/calc/ast"
+	"github.com/rthornton128/calc/comp"
+	"github.com/rthornton128/calc/load"
+	"github.com/rthornton128/calc/parse"
+	"github.com/rthornton128/calc/token"
+)
+
+// document is an open text document along with the results of parsing and
+// type checking its current contents as part of its package. Each document
+// has its own FileSet into which its package, and the packages it imports,
+// are loaded.
+type document struct {
+	uri   string
+	text  string
+	fset  *token.FileSet
+	tf    *token.File   // the document within fset, nil if it failed to load
+	file  *ast.File     // nil if the current text failed to load
+	pkg   *load.Package // package containing file
+	last  *ast.File     // most recent file to parse, used for completion
+	diags []diagnostic
+
+	uses   map[*ast.Ident]*ast.Object
+	idents []*ast.Ident // keys of uses in source order
+	scopes []scopeRange
+}
+
+// scopeRange records the extent of the source code covered by a scope
+type scopeRange struct {
+	scope      *ast.Scope
+	start, end token.Pos
+}
+
+func newDocument(uri, text string) *document {
+	d := &document{uri: uri}
+	d.update(text)
+	return d
+}
+
+func uriToPath(uri string) string {
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		return u.Path
+	}
+	return uri
+}
+
+// update replaces the text of the document then loads and type checks the
+// package containing it
+func (d *document) update(text string) {
+	d.text = text
+	d.fset = token.NewFileSet()
+	d.tf, d.file, d.pkg = nil, nil, nil
+	d.uses = make(map[*ast.Ident]*ast.Object)
+	d.idents, d.scopes = nil, nil
+
+	// files are named without their directory within a FileSet
+	path := uriToPath(d.uri)
+	name := filepath.Base(path)
+	pkg, err := d.load(path)
+	if err == nil {
+		for _, f := range pkg.Files {
+			if tf := d.fset.File(f.Pos()); tf != nil && tf.Name() == name {
+				d.tf, d.file, d.last, d.pkg = tf, f, f, pkg
+			}
+		}
+	}
+	if d.file != nil {
+		// identifiers must be resolved before type checking since the
+		// compiler replaces the values of objects as it goes. Those of the
+		// other files of the package are resolved too so that references
+		// are found throughout it.
+		for _, f := range pkg.Files {
+			for _, ob := range f.Scope.Objects() {
+				d.resolve(ob.Value, f.Scope)
+			}
+		}
+		for id := range d.uses {
+			d.idents = append(d.idents, id)
+		}
+		sort.Slice(d.idents, func(i, j int) bool {
+			return d.idents[i].NamePos < d.idents[j].NamePos
+		})
+		err = comp.CheckPackage(d.fset, pkg)
+	}
+	d.diags = d.diagnostics(err, name)
+}
+
+// load parses the package containing the document at path, using its text
+// in place of the file on disk, and loads the packages it imports. Each file
+// is parsed once. A test file is loaded with the tests of its package. A
+// directory in which more than one file declares main, like the examples
+// directory, holds a program in each file so the document is loaded on its
+// own. Any other directory holds a single package, which is only a main
+// package if it declares main.
+func (d *document) load(path string) (*load.Package, error) {
+	dir := filepath.Dir(path)
+	l := load.New(d.fset, load.SearchPath(dir))
+
+	// the document is parsed first so that it is the first file of fset
+	doc, err := parse.ParseSource(d.fset, path, d.text, nil)
+	if err != nil {
+		return nil, err
+	}
+	single := &ast.Package{Name: "main", Scope: doc.Scope,
+		Files: []*ast.File{doc}}
+	if !strings.HasPrefix(d.uri, "file:") {
+		return l.LoadPackage(single, dir)
+	}
+
+	test := parse.IsTestFile(path)
+	names, _ := filepath.Glob(filepath.Join(dir, "*.calc"))
+	if i := sort.SearchStrings(names, path); i == len(names) || names[i] != path {
+		names = append(names[:i], append([]string{path}, names[i:]...)...)
+	}
+	var files []*ast.File
+	var errors token.ErrorList
+	programs := 0
+	for _, name := range names {
+		if parse.IsTestFile(name) && !test {
+			continue
+		}
+		f := doc
+		if name != path {
+			f, err = parse.ParseFile(d.fset, name, nil)
+			if list, ok := err.(token.ErrorList); ok {
+				errors = append(errors, list...)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		files = append(files, f)
+		if !parse.IsTestFile(name) && f.Scope.Lookup("main") != nil {
+			programs++
+		}
+	}
+
+	if programs > 1 {
+		return l.LoadPackage(single, dir)
+	}
+	if errors.Count() > 0 {
+		return nil, errors
+	}
+	ap, err := parse.NewPackage(d.fset, dir, files)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case test:
+		pkg, err := l.LoadPackage(ap, dir)
+		if err == nil {
+			pkg.Test = true
+		}
+		return pkg, err
+	case ap.Scope.Lookup("main") != nil:
+		ap.Name = "main"
+	default:
+		// checked as an imported package, named after its directory since
+		// its import path is not known
+		ap.Path = ap.Name
+	}
+	return l.LoadPackage(ap, dir)
+}
+
+// diagnostics converts err to the diagnostics of the document, which is
+// named name. Errors in other files are left to be reported when those are
+// opened.
+func (d *document) diagnostics(err error, name string) []diagnostic {
+	diags := []diagnostic{}
+	if err == nil {
+		return diags
+	}
+
+	list, ok := err.(token.ErrorList)
+	if !ok {
+		return append(diags, diagnostic{
+			Severity: severityError,
+			Source:   "calc",
+			Message:  err.Error(),
+		})
+	}
+	for _, e := range list {
+		if fn := e.Pos().Filename; fn != "" && fn != name {
+			continue
+		}
+		start := lspPosition(d.text, e.Pos())
+		end := position{Line: start.Line, Character: start.Character + 1}
+		diags = append(diags, diagnostic{
+			Range:    lspRange{Start: start, End: end},
+			Severity: severityError,
+			Source:   "calc",
+			Message:  e.Msg(),
+		})
+	}
+	return diags
+}
+
+/* Position mapping */
+
+// pos returns the token.Pos corresponding to the LSP position p, whose
+// character counts UTF-16 code units
+func (d *document) pos(p position) token.Pos {
+	offset := 0
+	for line := 0; line < p.Line; line++ {
+		i := strings.IndexAny(d.text[offset:], "\r\n")
+		if i < 0 {
+			return token.Pos(d.base() + len(d.text))
+		}
+		offset += i + 1
+		if d.text[offset-1] == '\r' && strings.HasPrefix(d.text[offset:], "\n") {
+			offset++
+		}
+	}
+	for n, r := range d.text[offset:] {
+		if p.Character <= 0 || r == '\r' || r == '\n' {
+			return token.Pos(d.base() + offset + n)
+		}
+		p.Character -= utf16.RuneLen(r)
+	}
+	return token.Pos(d.base() + len(d.text))
+}
+
+// base returns the base of the document within its FileSet
+func (d *document) base() int {
+	if d.tf == nil {
+		return 1
+	}
+	return d.tf.Base()
+}
+
+// source returns the source text of the file containing p
+func (d *document) source(p token.Pos) string {
+	tf := d.fset.File(p)
+	if tf == nil || tf == d.tf {
+		return d.text
+	}
+	return d.fset.Text(token.Range{Start: token.Pos(tf.Base()), End: tf.End()})
+}
+
+// lspPosition converts a one based token.Position within the source src to
+// a zero based position. The column of a token.Position counts characters
+// whereas LSP counts UTF-16 code units. An unknown position becomes the
+// start of the document.
+func lspPosition(src string, tp token.Position) position {
+	if tp.Row < 1 || tp.Col < 1 {
+		return position{}
+	}
+	line := lineText(src, tp.Row)
+	if tp.Row == 1 {
+		line = strings.TrimPrefix(line, "\uFEFF")
+	}
+	char, col := 0, 1
+	for _, r := range line {
+		if col == tp.Col {
+			break
+		}
+		char += utf16.RuneLen(r)
+		col++
+	}
+	return position{Line: tp.Row - 1, Character: char + tp.Col - col}
+}
+
+// lineText returns the text of line row, counting from one, of src without
+// its line terminator
+func lineText(src string, row int) string {
+	for ; row > 1; row-- {
+		i := strings.IndexAny(src, "\r\n")
+		if i < 0 {
+			return ""
+		}
+		if strings.HasPrefix(src[i:], "\r\n") {
+			i++
+		}
+		src = src[i+1:]
+	}
+	if i := strings.IndexAny(src, "\r\n"); i >= 0 {
+		src = src[:i]
+	}
+	return src
+}
+
+// fileURI returns the URI of the file containing p, which may be another
+// file of the package or of a package it imports
+func (d *document) fileURI(p token.Pos) string {
+	tf := d.fset.File(p)
+	if tf == nil || tf == d.tf || d.pkg == nil {
+		return d.uri
+	}
+	for _, pkg := range load.Packages(d.pkg) {
+		for _, f := range pkg.Files {
+			if d.fset.File(f.Pos()) == tf {
+				path, _ := filepath.Abs(filepath.Join(pkg.Dir, tf.Name()))
+				return "file://" + filepath.ToSlash(path)
+			}
+		}
+	}
+	return d.uri
+}
+
+// lspRange converts a range of source code to an LSP range
+func (d *document) lspRange(r token.Range) lspRange {
+	start, end := d.fset.RangePosition(r)
+	src := d.source(r.Start)
+	return lspRange{Start: lspPosition(src, start), End: lspPosition(src, end)}
+}
+
+func (d *document) nodeRange(n ast.Node) lspRange {
+	return d.lspRange(ast.RangeOf(n))
+}
+
+/* Resolution */
+
+func (d *document) use(id *ast.Ident, s *ast.Scope) {
+	if ob := s.Resolve(id.Name); ob != nil {
+		d.uses[id] = ob
+	}
+}
+
+// resolve records the object each identifier within n refers to along with
+// the extents of any scopes n opens
+func (d *document) resolve(n ast.Node, s *ast.Scope) {
+	if n == nil || reflect.ValueOf(n).IsNil() {
+		return
+	}
+
+	switch n := n.(type) {
+	case *ast.AssignExpr:
+		d.use(n.Name, s)
+		d.resolve(n.Value, s)
+	case *ast.BinaryExpr:
+		for _, v := range n.List {
+			d.resolve(v, s)
+		}
+	case *ast.CallExpr:
+		d.use(n.Name, s)
+		for _, v := range n.Args {
+			d.resolve(v, s)
+		}
+	case *ast.DeclExpr:
+		d.use(n.Name, s)
+		d.scopes = append(d.scopes, scopeRange{n.Scope, n.Opening, n.Closing})
+		for _, p := range n.Params {
+			d.use(p, n.Scope)
+		}
+		d.resolve(n.Body, n.Scope)
+	case *ast.ExprList:
+		for _, v := range n.List {
+			d.resolve(v, s)
+		}
+	case *ast.Ident:
+		d.use(n, s)
+	case *ast.IfExpr:
+		d.resolve(n.Cond, s)
+		d.scopes = append(d.scopes, scopeRange{n.Scope, n.Opening, n.Closing})
+		d.resolve(n.Then, n.Scope)
+		d.resolve(n.Else, n.Scope)
+	case *ast.UnaryExpr:
+		d.resolve(n.Value, s)
+	case *ast.VarExpr:
+		d.use(n.Name, s)
+		if a, ok := n.Object.Value.(*ast.AssignExpr); ok && a != nil {
+			d.resolve(a.Value, s)
+		}
+	}
+}
+
+/* Queries */
+
+// identAt returns the resolved identifier at p or, if there is none, the
+// one ending immediately before p
+func (d *document) identAt(p token.Pos) (*ast.Ident, *ast.Object) {
+	i := sort.Search(len(d.idents), func(i int) bool {
+		return d.idents[i].End() > p
+	})
+	switch {
+	case i < len(d.idents) && d.idents[i].NamePos <= p:
+	case i > 0 && d.idents[i-1].End() == p:
+		i--
+	default:
+		return nil, nil
+	}
+	return d.idents[i], d.uses[d.idents[i]]
+}
+
+// references returns all identifiers of the package referring to ob in
+// source order
+func (d *document) references(ob *ast.Object) []*ast.Ident {
+	var refs []*ast.Ident
+	for _, id := range d.idents {
+		if d.uses[id] == ob {
+			refs = append(refs, id)
+		}
+	}
+	return refs
+}
+
+// scopeAt returns the innermost scope enclosing p
+func (d *document) scopeAt(p token.Pos) *ast.Scope {
+	if d.file == nil {
+		if d.last == nil {
+			return nil
+		}
+		return d.last.Scope
+	}
+
+	scope, size := d.file.Scope, token.Pos(0)
+	for _, r := range d.scopes {
+		if r.start < p && p <= r.end && (size == 0 || r.end-r.start < size) {
+			scope, size = r.scope, r.end-r.start
+		}
+	}
+	return scope
+}
+
+// decls returns the top-level function declarations in source order
+func (d *document) decls() []*ast.DeclExpr {
+	var decls []*ast.DeclExpr
+	if d.file == nil {
+		return decls
+	}
+	for _, ob := range d.file.Scope.Objects() {
+		if decl, ok := ob.Value.(*ast.DeclExpr); ok && ob.Kind == ast.Decl {
+			decls = append(decls, decl)
+		}
+	}
+	return decls
+}
+
+func typeName(ob *ast.Object) string {
+	if ob.Type == nil {
+		return "unknown"
+	}
+	return ob.Type.Name
+}
@@ -0,0 +1,297 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/rthornton128/calc/ast"
+	"github.com/rthornton128/calc/doc"
+	"github.com/rthornton128/calc/token"
+)
+
+type server struct {
+	in       *bufio.Reader
+	out      io.Writer
+	docs     map[string]*document
+	shutdown bool
+}
+
+func newServer(r io.Reader, w io.Writer) *server {
+	return &server{
+		in:   bufio.NewReader(r),
+		out:  w,
+		docs: make(map[string]*document),
+	}
+}
+
+// serve reads and handles messages until an exit notification is received
+// or the input is closed
+func (s *server) serve() error {
+	for {
+		buf, err := readMessage(s.in)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(buf, &req); err != nil {
+			if err := s.replyError(nil, &rpcError{codeParseError, err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			return nil
+		}
+
+		result, rerr := s.handle(&req)
+		if req.ID == nil {
+			continue
+		}
+		if rerr != nil {
+			err = s.replyError(req.ID, rerr)
+		} else {
+			err = writeMessage(s.out, &response{JSONRPC: "2.0", ID: req.ID, Result: result})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *server) replyError(id *json.RawMessage, e *rpcError) error {
+	return writeMessage(s.out, &errorResponse{JSONRPC: "2.0", ID: id, Error: e})
+}
+
+func (s *server) notify(method string, params interface{}) error {
+	return writeMessage(s.out, &notification{JSONRPC: "2.0", Method: method,
+		Params: params})
+}
+
+func (s *server) handle(req *request) (interface{}, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		return s.initialize()
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		d := newDocument(params.TextDocument.URI, params.TextDocument.Text)
+		s.docs[d.uri] = d
+		return nil, s.publish(d.uri, d.diags)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		d, ok := s.docs[params.TextDocument.URI]
+		if !ok || len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		d.update(params.ContentChanges[len(params.ContentChanges)-1].Text)
+		return nil, s.publish(d.uri, d.diags)
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, s.publish(params.TextDocument.URI, []diagnostic{})
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		return s.hover(&params), nil
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		return s.definition(&params), nil
+	case "textDocument/references":
+		var params referenceParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		return s.references(&params), nil
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		return s.documentSymbols(&params), nil
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		return s.completion(&params), nil
+	}
+	return nil, &rpcError{codeMethodNotFound, "method not found: " + req.Method}
+}
+
+func unmarshalParams(req *request, v interface{}) *rpcError {
+	if err := json.Unmarshal(req.Params, v); err != nil {
+		return &rpcError{codeInvalidParams, err.Error()}
+	}
+	return nil
+}
+
+func (s *server) publish(uri string, diags []diagnostic) *rpcError {
+	err := s.notify("textDocument/publishDiagnostics",
+		&publishDiagnosticsParams{URI: uri, Diagnostics: diags})
+	if err != nil {
+		return &rpcError{codeInternalError, err.Error()}
+	}
+	return nil
+}
+
+/* Requests */
+
+func (s *server) initialize() (interface{}, *rpcError) {
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync":       1, // full document sync
+			"hoverProvider":          true,
+			"definitionProvider":     true,
+			"referencesProvider":     true,
+			"documentSymbolProvider": true,
+			"completionProvider":     map[string]interface{}{},
+		},
+		"serverInfo": map[string]string{"name": "calcls"},
+	}, nil
+}
+
+func (s *server) hover(params *textDocumentPositionParams) *hover {
+	d, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	id, ob := d.identAt(d.pos(params.Position))
+	if id == nil {
+		return nil
+	}
+
+	var text string
+	switch decl, ok := ob.Value.(*ast.DeclExpr); {
+	case ok && ob.Kind == ast.Decl:
+		f := doc.NewFunc(d.fset, decl)
+		text = "```calc\n" + f.Signature() + "\n```\n"
+		if f.Doc != "" {
+			text += "\n" + f.Doc
+		}
+	default:
+		text = "```calc\n(var " + ob.Name + " " + typeName(ob) + ")\n```\n"
+	}
+
+	r := d.nodeRange(id)
+	return &hover{Contents: markupContent{Kind: "markdown", Value: text}, Range: &r}
+}
+
+func (s *server) definition(params *textDocumentPositionParams) []location {
+	d, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	_, ob := d.identAt(d.pos(params.Position))
+	if ob == nil || !ob.NamePos.Valid() {
+		return nil
+	}
+	r := d.lspRange(token.Range{Start: ob.NamePos,
+		End: ob.NamePos + token.Pos(len(ob.Name))})
+	return []location{{URI: d.fileURI(ob.NamePos), Range: r}}
+}
+
+func (s *server) references(params *referenceParams) []location {
+	d, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	_, ob := d.identAt(d.pos(params.Position))
+	if ob == nil {
+		return nil
+	}
+
+	locs := []location{}
+	for _, id := range d.references(ob) {
+		if id.NamePos == ob.NamePos && !params.Context.IncludeDeclaration {
+			continue
+		}
+		locs = append(locs, location{URI: d.fileURI(id.NamePos),
+			Range: d.nodeRange(id)})
+	}
+	return locs
+}
+
+func (s *server) documentSymbols(params *documentSymbolParams) []documentSymbol {
+	d, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+
+	syms := []documentSymbol{}
+	for _, decl := range d.decls() {
+		syms = append(syms, documentSymbol{
+			Name:           decl.Name.Name,
+			Detail:         doc.NewFunc(d.fset, decl).Signature(),
+			Kind:           symbolFunction,
+			Range:          d.nodeRange(decl),
+			SelectionRange: d.nodeRange(decl.Name),
+		})
+	}
+	return syms
+}
+
+func (s *server) completion(params *textDocumentPositionParams) *completionList {
+	d, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+
+	list := &completionList{Items: []completionItem{}}
+	seen := make(map[string]bool)
+	for scope := d.scopeAt(d.pos(params.Position)); scope != nil; scope = scope.Parent {
+		var items []completionItem
+		for _, ob := range scope.Objects() {
+			if seen[ob.Name] {
+				continue
+			}
+			seen[ob.Name] = true
+
+			item := completionItem{Label: ob.Name, Kind: completionVariable,
+				Detail: typeName(ob)}
+			if ob.Kind == ast.Decl {
+				item.Kind = completionFunction
+				if decl, ok := ob.Value.(*ast.DeclExpr); ok {
+					item.Detail = doc.NewFunc(d.fset, decl).Signature()
+				}
+			}
+			items = append(items, item)
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+		list.Items = append(list.Items, items...)
+	}
+	for _, kw := range []string{"decl", "if", "import", "var"} {
+		list.Items = append(list.Items, completionItem{Label: kw, Kind: completionKeyword})
+	}
+	return list
+}
@@ -0,0 +1,385 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// client is a scripted JSON-RPC client connected to a server
+type client struct {
+	t     *testing.T
+	w     io.WriteCloser
+	r     *bufio.Reader
+	id    int
+	diags []publishDiagnosticsParams
+	done  chan error
+}
+
+func newClient(t *testing.T) *client {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	c := &client{t: t, w: inW, r: bufio.NewReader(outR), done: make(chan error)}
+	go func() {
+		s := newServer(inR, outW)
+		err := s.serve()
+		if err == nil && !s.shutdown {
+			err = io.ErrUnexpectedEOF
+		}
+		outW.Close()
+		c.done <- err
+	}()
+	return c
+}
+
+type message struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// read returns the next message, recording any diagnostics published
+func (c *client) read() *message {
+	buf, err := readMessage(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var m message
+	if err := json.Unmarshal(buf, &m); err != nil {
+		c.t.Fatal(err)
+	}
+	if m.Method == "textDocument/publishDiagnostics" {
+		var p publishDiagnosticsParams
+		if err := json.Unmarshal(m.Params, &p); err != nil {
+			c.t.Fatal(err)
+		}
+		c.diags = append(c.diags, p)
+	}
+	return &m
+}
+
+func (c *client) notify(method string, params interface{}) {
+	err := writeMessage(c.w, &notification{JSONRPC: "2.0", Method: method,
+		Params: params})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// call sends a request and decodes the result of the response into result
+func (c *client) call(method string, params, result interface{}) {
+	c.id++
+	err := writeMessage(c.w, map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      c.id,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		m := c.read()
+		if m.ID == nil || *m.ID != c.id {
+			continue
+		}
+		if m.Error != nil {
+			c.t.Fatal(method, m.Error)
+		}
+		if result != nil {
+			if err := json.Unmarshal(m.Result, result); err != nil {
+				c.t.Fatal(method, err)
+			}
+		}
+		return
+	}
+}
+
+// lastDiagnostics reads the diagnostics published after a notification
+func (c *client) lastDiagnostics() []diagnostic {
+	c.diags = nil
+	for len(c.diags) == 0 {
+		c.read()
+	}
+	return c.diags[0].Diagnostics
+}
+
+// open opens the example at path, relative to the examples directory, with
+// the given text or, if text is empty, its contents on disk and returns its
+// URI and the diagnostics published for it
+func (c *client) open(path, text string) (string, []diagnostic) {
+	path, err := filepath.Abs(filepath.Join("..", "examples", path))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if text == "" {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		text = string(buf)
+	}
+	uri := "file://" + filepath.ToSlash(path)
+	c.notify("textDocument/didOpen", &didOpenParams{TextDocument: textDocumentItem{
+		URI: uri, LanguageID: "calc", Version: 1, Text: text}})
+	return uri, c.lastDiagnostics()
+}
+
+const uri = "file:///tmp/test.calc"
+
+const badSrc = `(decl main int (foo))`
+
+const goodSrc = `; add returns the sum of a and b
+(decl add (a b int) int (+ a b))
+
+(decl main int (
+	(var (= x 2))
+	(add x 3)))
+`
+
+func at(line, char int) textDocumentPositionParams {
+	return textDocumentPositionParams{
+		TextDocument: textDocumentIdentifier{URI: uri},
+		Position:     position{Line: line, Character: char},
+	}
+}
+
+func TestServer(t *testing.T) {
+	c := newClient(t)
+
+	var init struct {
+		Capabilities map[string]interface{} `json:"capabilities"`
+	}
+	c.call("initialize", map[string]interface{}{}, &init)
+	if init.Capabilities["hoverProvider"] != true {
+		t.Fatal("Expected: hoverProvider Got:", init.Capabilities)
+	}
+	c.notify("initialized", map[string]interface{}{})
+
+	c.notify("textDocument/didOpen", &didOpenParams{TextDocument: textDocumentItem{
+		URI: uri, LanguageID: "calc", Version: 1, Text: badSrc}})
+	diags := c.lastDiagnostics()
+	if len(diags) == 0 || diags[0].Range.Start != (position{0, 16}) {
+		t.Fatal("Expected: diagnostic at 0:16 Got:", diags)
+	}
+
+	change := map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []map[string]string{{"text": goodSrc}},
+	}
+	c.notify("textDocument/didChange", change)
+	if diags := c.lastDiagnostics(); len(diags) != 0 {
+		t.Fatal("Expected: no diagnostics Got:", diags)
+	}
+
+	var h hover
+	c.call("textDocument/hover", at(5, 3), &h)
+	if h.Contents.Value != "```calc\n(decl add (a b int) int)\n```\n\n"+
+		"add returns the sum of a and b\n" {
+		t.Fatalf("Unexpected hover: %q", h.Contents.Value)
+	}
+	c.call("textDocument/hover", at(5, 6), &h)
+	if h.Contents.Value != "```calc\n(var x int)\n```\n" {
+		t.Fatalf("Unexpected hover: %q", h.Contents.Value)
+	}
+
+	var locs []location
+	c.call("textDocument/definition", at(5, 3), &locs)
+	if len(locs) != 1 || locs[0].Range != (lspRange{position{1, 6}, position{1, 9}}) {
+		t.Fatal("Unexpected definition:", locs)
+	}
+	c.call("textDocument/definition", at(1, 28), &locs)
+	if len(locs) != 1 || locs[0].Range.Start != (position{1, 11}) {
+		t.Fatal("Unexpected parameter definition:", locs)
+	}
+
+	refs := referenceParams{textDocumentPositionParams: at(4, 9)}
+	refs.Context.IncludeDeclaration = true
+	c.call("textDocument/references", refs, &locs)
+	if len(locs) != 2 || locs[0].Range.Start != (position{4, 9}) ||
+		locs[1].Range.Start != (position{5, 6}) {
+		t.Fatal("Unexpected references:", locs)
+	}
+
+	var syms []documentSymbol
+	c.call("textDocument/documentSymbol",
+		documentSymbolParams{TextDocument: textDocumentIdentifier{URI: uri}}, &syms)
+	if len(syms) != 2 || syms[0].Name != "add" || syms[1].Name != "main" ||
+		syms[1].Range != (lspRange{position{3, 0}, position{5, 12}}) {
+		t.Fatal("Unexpected symbols:", syms)
+	}
+
+	var list completionList
+	c.call("textDocument/completion", at(5, 2), &list)
+	var labels []string
+	for _, item := range list.Items {
+		labels = append(labels, item.Label)
+	}
+	expected := []string{"x", "add", "main", "decl", "if", "import", "var"}
+	if len(labels) != len(expected) {
+		t.Fatal("Expected:", expected, "Got:", labels)
+	}
+	for i := range expected {
+		if labels[i] != expected[i] {
+			t.Fatal("Expected:", expected, "Got:", labels)
+		}
+	}
+
+	c.call("shutdown", nil, nil)
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPackage(t *testing.T) {
+	c := newClient(t)
+	c.call("initialize", map[string]interface{}{}, nil)
+
+	// the unsaved text is checked rather than the file
+	_, diags := c.open("package/main.calc", "(decl main int (add 2))")
+	if len(diags) != 1 || !strings.Contains(diags[0].Message,
+		"number of arguments") {
+		t.Fatal("Expected: argument count diagnostic Got:", diags)
+	}
+	// add is declared in add.calc
+	uri, diags := c.open("package/main.calc", "")
+	if len(diags) != 0 {
+		t.Fatal("Expected: no diagnostics Got:", diags)
+	}
+	// add is also referred to, and declared, in add.calc
+	refs := referenceParams{textDocumentPositionParams: textDocumentPositionParams{
+		TextDocument: textDocumentIdentifier{URI: uri},
+		Position:     position{Line: 2, Character: 17},
+	}}
+	refs.Context.IncludeDeclaration = true
+	var locs []location
+	c.call("textDocument/references", refs, &locs)
+	found := 0
+	for _, loc := range locs {
+		switch {
+		case loc.URI == uri && loc.Range.Start == (position{2, 16}),
+			strings.HasSuffix(loc.URI, "/package/add.calc") &&
+				loc.Range.Start == (position{1, 6}):
+			found++
+		}
+	}
+	if len(locs) != 2 || found != 2 {
+		t.Fatal("Unexpected references:", locs)
+	}
+	// a package without main is not a program
+	if _, diags := c.open("import/shapes/rect.calc", ""); len(diags) != 0 {
+		t.Fatal("Expected: no diagnostics Got:", diags)
+	}
+	// each file of the examples directory is a program of its own
+	if _, diags := c.open("abs.calc", ""); len(diags) != 0 {
+		t.Fatal("Expected: no diagnostics Got:", diags)
+	}
+	_, diags = c.open("no_main.calc", "")
+	if len(diags) != 1 || !strings.Contains(diags[0].Message, "no entry point") {
+		t.Fatal("Expected: no entry point diagnostic Got:", diags)
+	}
+
+	c.call("shutdown", nil, nil)
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	c := newClient(t)
+	c.call("initialize", map[string]interface{}{}, nil)
+
+	uri, diags := c.open("import/main.calc", "")
+	if len(diags) != 0 {
+		t.Fatal("Expected: no diagnostics Got:", diags)
+	}
+	pos := textDocumentPositionParams{
+		TextDocument: textDocumentIdentifier{URI: uri},
+		Position:     position{Line: 4, Character: 18},
+	}
+	var h hover
+	c.call("textDocument/hover", pos, &h)
+	if !strings.HasPrefix(h.Contents.Value, "```calc\n(decl Area (w h int) int)") {
+		t.Fatalf("Unexpected hover: %q", h.Contents.Value)
+	}
+	var locs []location
+	c.call("textDocument/definition", pos, &locs)
+	if len(locs) != 1 || !strings.HasSuffix(locs[0].URI,
+		"/examples/import/shapes/rect.calc") ||
+		locs[0].Range != (lspRange{position{1, 6}, position{1, 10}}) {
+		t.Fatal("Unexpected definition:", locs)
+	}
+
+	_, diags = c.open("import/main.calc",
+		"(import \"shapes\")\n(decl main int (shapes.mul 3 4))")
+	if len(diags) != 1 || !strings.Contains(diags[0].Message, "unexported") {
+		t.Fatal("Expected: unexported name diagnostic Got:", diags)
+	}
+
+	c.call("shutdown", nil, nil)
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUTF16(t *testing.T) {
+	c := newClient(t)
+	c.call("initialize", map[string]interface{}{}, nil)
+
+	// 𝔸 is two UTF-16 code units and four bytes, é is one and two
+	src := "(decl main int ((assert 1 \"𝔸\") (var (= éé 2)) (+ éé 1)))"
+	c.notify("textDocument/didOpen", &didOpenParams{TextDocument: textDocumentItem{
+		URI: uri, LanguageID: "calc", Version: 1, Text: src}})
+	if diags := c.lastDiagnostics(); len(diags) != 0 {
+		t.Fatal("Expected: no diagnostics Got:", diags)
+	}
+
+	var h hover
+	c.call("textDocument/hover", at(0, 51), &h)
+	if h.Range == nil || *h.Range != (lspRange{position{0, 50}, position{0, 52}}) {
+		t.Fatal("Unexpected hover range:", h.Range)
+	}
+	var locs []location
+	c.call("textDocument/definition", at(0, 50), &locs)
+	if len(locs) != 1 || locs[0].Range != (lspRange{position{0, 40},
+		position{0, 42}}) {
+		t.Fatal("Unexpected definition:", locs)
+	}
+
+	c.call("shutdown", nil, nil)
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	for _, n := range []string{"-1", "abc", "99999999999"} {
+		r := bufio.NewReader(strings.NewReader("Content-Length: " + n +
+			"\r\n\r\n{}"))
+		if _, err := readMessage(r); err == nil {
+			t.Fatal("Expected: error for Content-Length", n, "Got: nil")
+		}
+	}
+	r := bufio.NewReader(strings.NewReader("Content-Length: 2\r\n\r\n{}"))
+	if buf, err := readMessage(r); err != nil || string(buf) != "{}" {
+		t.Fatal("Expected: {} Got:", string(buf), err)
+	}
+}
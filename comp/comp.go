@@ -11,44 +11,153 @@ package comp
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
 
 	"github.com/rthornton128/calc/ast"
-	"github.com/rthornton128/calc/parse"
+	"github.com/rthornton128/calc/load"
 	"github.com/rthornton128/calc/token"
 )
 
 type compiler struct {
-	fp       *os.File
+	fp       io.Writer
 	fset     *token.FileSet
 	errors   token.ErrorList
 	offset   int
+	pkg      *ast.Package
 	curScope *ast.Scope
+	ints     map[*ast.BasicLit]constant // integer literals already parsed
+	folds    map[ast.Expr]constant      // expressions already folded
+}
+
+// constant is the value of a constant expression, valid if ok is true
+type constant struct {
+	val int
+	ok  bool
 }
 
 // CompileFile generates a C source file for the corresponding file
 // specified by path. The .calc extension for the filename in path is
-// replaced with .c for the C source output.
+// replaced with .c for the C source output. Imported packages are found
+// using load.SearchPath and compiled as by CompileProgram.
 func CompileFile(path string) error {
-	var c compiler
-
-	c.fset = token.NewFileSet()
-	f, err := parse.ParseFile(c.fset, path, nil)
+	fset := token.NewFileSet()
+	l := load.New(fset, load.SearchPath(filepath.Dir(path)))
+	pkg, err := l.LoadFile(path)
 	if err != nil {
 		return err
 	}
 
-	path = path[:len(path)-len(filepath.Ext(path))]
-	fp, err := os.Create(path + ".c")
+	name := filepath.Base(path)
+	name = name[:len(name)-len(filepath.Ext(name))]
+	_, err = CompileProgram(filepath.Dir(path), name, fset, pkg)
+	return err
+}
+
+// CompileDir generates C source code for the Calc sources found in the
+// directory specified by path. The C source file uses the same name as
+// directory rather than any individual file. Imported packages are found
+// using load.SearchPath and compiled as by CompileProgram.
+func CompileDir(path string) error {
+	fset := token.NewFileSet()
+	l := load.New(fset, load.SearchPath(path))
+	pkg, err := l.LoadDir(path)
 	if err != nil {
 		return err
 	}
-	defer fp.Close()
 
-	c.fp = fp
+	_, err = CompileProgram(path, filepath.Base(path), fset, pkg)
+	return err
+}
+
+// CompileProgram generates C source code in dir for the main package pkg
+// and every package it imports, directly or indirectly. The main package is
+// written to name.c and each imported package to a C source file and
+// header named by BaseName. The names of the C source files are returned in
+// an order in which each package follows the packages it imports. No files
+// are left behind if an error occurs.
+func CompileProgram(dir, name string, fset *token.FileSet,
+	pkg *load.Package) ([]string, error) {
+	var files []string
+	var errors token.ErrorList
+	for _, p := range load.Packages(pkg) {
+		base := name
+		if p.Path != "" {
+			base = BaseName(p.Path)
+		}
+		base = filepath.Join(dir, base)
+		err := writeFiles(base, p.Path != "", func(src, hdr io.Writer) error {
+			return CompilePackage(src, hdr, fset, p)
+		})
+		if list, ok := err.(token.ErrorList); ok {
+			errors = append(errors, list...)
+			continue
+		}
+		files = append(files, base+".c")
+		if err != nil {
+			removeFiles(files)
+			return nil, err
+		}
+	}
+	if errors.Count() != 0 {
+		removeFiles(files)
+		return nil, errors
+	}
+	return files, nil
+}
+
+// CompilePackage writes the C source code for pkg to src. The C main
+// function is generated only for the main package. If pkg was loaded by
+// load.Loader.LoadTest the C main function runs its tests instead and pkg
+// need not declare main. Any other package is given a header, written to
+// hdr, declaring its exported functions for the packages importing it; hdr
+// is unused for the main package and may be nil. Functions that are not
+// exported are static.
+func CompilePackage(src, hdr io.Writer, fset *token.FileSet,
+	pkg *load.Package) error {
+	c := &compiler{fp: src, fset: fset}
+	c.compPackage(pkg, hdr)
+
+	if c.errors.Count() != 0 {
+		return c.errors
+	}
+	return nil
+}
+
+// BaseName returns the name, without an extension, of the C source,
+// header and object files generated for the package with the given import
+// path. It is also the prefix of every C function generated for the
+// package. ASCII letters and digits are kept while any other byte is
+// escaped by an underscore, a slash as _s, an underscore as _u and anything
+// else as _x and its value in hexadecimal, so that no two import paths
+// share a name.
+func BaseName(importPath string) string {
+	var b strings.Builder
+	for i := 0; i < len(importPath); i++ {
+		switch ch := importPath[i]; {
+		case 'a' <= ch && ch <= 'z', 'A' <= ch && ch <= 'Z',
+			'0' <= ch && ch <= '9':
+			b.WriteByte(ch)
+		case ch == '/':
+			b.WriteString("_s")
+		case ch == '_':
+			b.WriteString("_u")
+		default:
+			fmt.Fprintf(&b, "_x%02x", ch)
+		}
+	}
+	return b.String()
+}
+
+// CheckFile type checks the already parsed file f without generating any
+// code. The returned error, if not nil, is a token.ErrorList.
+func CheckFile(fset *token.FileSet, f *ast.File) error {
+	c := &compiler{fp: ioutil.Discard, fset: fset}
 	c.compFile(f)
 
 	if c.errors.Count() != 0 {
@@ -57,29 +166,46 @@ func CompileFile(path string) error {
 	return nil
 }
 
-// CompileDir generates C source code for the Calc sources found in the
-// directory specified by path. The C source file uses the same name as
-// directory rather than any individual file.
-func CompileDir(path string) error {
-	fs := token.NewFileSet()
-	pkg, err := parse.ParseDir(fs, path)
-	if err != nil {
-		return err
+// CheckPackage type checks the already loaded package pkg without
+// generating any code. Like CompilePackage, only the main package must
+// declare main. The returned error, if not nil, is a token.ErrorList.
+func CheckPackage(fset *token.FileSet, pkg *load.Package) error {
+	return CompilePackage(ioutil.Discard, ioutil.Discard, fset, pkg)
+}
+
+// removeFiles removes the C source files in csrc and their headers
+func removeFiles(csrc []string) {
+	for _, src := range csrc {
+		os.Remove(src)
+		os.Remove(src[:len(src)-len(".c")] + ".h")
 	}
+}
 
-	fp, err := os.Create(filepath.Join(path, filepath.Base(path)) + ".c")
+// writeFiles creates base.c, and base.h if header is true, and passes them
+// to write. Both files are removed if write fails.
+func writeFiles(base string, header bool,
+	write func(src, hdr io.Writer) error) error {
+	src, err := os.Create(base + ".c")
 	if err != nil {
 		return err
 	}
-	defer fp.Close()
+	defer src.Close()
 
-	c := &compiler{fp: fp, fset: fs}
-	c.compPackage(pkg)
+	var hdr io.Writer
+	if header {
+		fp, err := os.Create(base + ".h")
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		hdr = fp
+	}
 
-	if c.errors.Count() != 0 {
-		return c.errors
+	if err = write(src, hdr); err != nil {
+		os.Remove(base + ".c")
+		os.Remove(base + ".h")
 	}
-	return nil
+	return err
 }
 
 /* Utility */
@@ -97,6 +223,40 @@ func roundUp16(n int) int {
 	return n
 }
 
+// lookup returns the object referred to by id, which may be qualified by
+// the name of an imported package. Only exported objects may be referred
+// to from outside their package.
+func (c *compiler) lookup(id *ast.Ident) *ast.Object {
+	ob := c.curScope.Resolve(id.Name)
+	if pkg, sel := ast.SplitQualified(id.Name); pkg != "" && ob != nil &&
+		!ast.IsExported(sel) {
+		c.Error(id.NamePos, "cannot refer to unexported name '", id.Name, "'")
+	}
+	return ob
+}
+
+// symbol returns the C function name for the Calc function name, which may
+// be qualified by the name of an imported package. Names declared in an
+// imported package are prefixed by its import path so that functions of
+// the same name in different packages do not collide.
+func (c *compiler) symbol(name string) string {
+	pkg, sel := ast.SplitQualified(name)
+	path := ""
+	if pkg != "" {
+		if ob := c.curScope.Lookup(pkg); ob != nil && ob.Kind == ast.Pkg {
+			path = ob.Value.(*ast.ImportExpr).ImportPath()
+		}
+	} else if c.pkg != nil {
+		path = c.pkg.Path
+	}
+	if path == "" {
+		return "_" + sel
+	}
+	// every underscore in BaseName is followed by s, u or x, and names
+	// have none, so the double underscore separates them unambiguously
+	return "_" + BaseName(path) + "__" + sel
+}
+
 func (c *compiler) nextOffset() (offset int) {
 	offset = c.offset
 	c.offset += 4
@@ -144,11 +304,16 @@ func (c *compiler) compNode(node ast.Node) {
 }
 
 func (c *compiler) compAssignExpr(a *ast.AssignExpr) {
-	ob := c.curScope.Lookup(a.Name.Name)
+	ob := c.lookup(a.Name)
 	if ob == nil {
 		c.Error(a.Name.NamePos, "undeclared variable '", a.Name.Name, "'")
 		return
 	}
+	if ob.Kind != ast.Var {
+		c.Error(a.Name.NamePos, "may not assign to object that is not a "+
+			"variable")
+		return
+	}
 
 	if ob.Type == nil {
 		ob.Type = typeOf(a.Value, c.curScope)
@@ -231,6 +396,18 @@ func (c *compiler) compBinaryExpr(b *ast.BinaryExpr) {
 			fmt.Fprintln(c.fp, "nel(eax, edx);")
 		case token.OR:
 			fmt.Fprintln(c.fp, "orl(eax, edx);")
+		case token.BAND:
+			fmt.Fprintln(c.fp, "bandl(edx, eax);")
+		case token.BOR:
+			fmt.Fprintln(c.fp, "borl(edx, eax);")
+		case token.XOR:
+			fmt.Fprintln(c.fp, "xorl(edx, eax);")
+		case token.ANDNOT:
+			fmt.Fprintln(c.fp, "andnl(edx, eax);")
+		case token.SHL:
+			fmt.Fprintln(c.fp, "shll(edx, eax);")
+		case token.SHR:
+			fmt.Fprintln(c.fp, "sarl(edx, eax);")
 		}
 	}
 }
@@ -238,8 +415,11 @@ func (c *compiler) compBinaryExpr(b *ast.BinaryExpr) {
 func (c *compiler) compCallExpr(e *ast.CallExpr) {
 	offset := 4
 
-	ob := c.curScope.Lookup(e.Name.Name)
+	ob := c.lookup(e.Name)
 	switch {
+	case ob == nil && e.Name.Name == "assert":
+		c.compAssert(e)
+		return
 	case e.Name.Name == "main":
 		c.Error(e.Name.NamePos, "illegal to call function 'main'")
 		return
@@ -273,7 +453,7 @@ func (c *compiler) compCallExpr(e *ast.CallExpr) {
 		}
 		offset += 4
 	}
-	fmt.Fprintf(c.fp, "_%s();\n", e.Name.Name)
+	fmt.Fprintf(c.fp, "%s();\n", c.symbol(e.Name.Name))
 	return
 }
 
@@ -286,7 +466,7 @@ func (c *compiler) compDeclExpr(d *ast.DeclExpr) {
 		ob.Offset = c.nextOffset()
 	}
 
-	fmt.Fprintf(c.fp, "void _%s(void) {\n", d.Name.Name)
+	fmt.Fprintf(c.fp, "void %s(void) {\n", c.symbol(d.Name.Name))
 	if x := c.countVars(d) * 4; x > 0 {
 		fmt.Fprintf(c.fp, "enter(%d);\n", roundUp16(x))
 		c.compNode(d.Body)
@@ -304,14 +484,15 @@ func (c *compiler) compDeclExpr(d *ast.DeclExpr) {
 }
 
 func (c *compiler) compFile(f *ast.File) {
-	c.curScope = f.Scope
-	c.compTopScope()
+	c.compPackage(&load.Package{Package: &ast.Package{Name: "main",
+		Scope: f.Scope, Files: []*ast.File{f}}}, nil)
 }
 
 func (c *compiler) compIdent(n *ast.Ident, format string) {
-	ob := c.curScope.Lookup(n.Name)
+	ob := c.lookup(n)
 	if ob == nil {
-		panic("no offset for identifier")
+		c.Error(n.NamePos, "undeclared identifier '", n.Name, "'")
+		return
 	}
 	fmt.Fprintf(c.fp, format, ob.Offset)
 }
@@ -337,28 +518,102 @@ func (c *compiler) compIfExpr(n *ast.IfExpr) {
 }
 
 func (c *compiler) compInt(n *ast.BasicLit, reg string) {
-	i, err := strconv.Atoi(n.Lit)
-	if err != nil {
-		c.Error(n.Pos(), "bad conversion:", err)
+	if n.Kind != token.INTEGER {
+		c.Error(n.Pos(), "string literal may only be used as the message of "+
+			"assert")
+		return
 	}
+	i, _ := c.intValue(n)
 	fmt.Fprintf(c.fp, "setl(%d, %s);\n", i, reg)
 }
 
-func (c *compiler) compPackage(p *ast.Package) {
+// intValue returns the value of the integer literal n and whether it is
+// valid. Each literal is parsed once, however many times it is compiled,
+// so that a literal out of range is reported once.
+func (c *compiler) intValue(n *ast.BasicLit) (int, bool) {
+	lit, ok := c.ints[n]
+	if !ok {
+		i, err := strconv.ParseInt(n.Lit, 0, 32)
+		if err != nil {
+			c.Error(n.Pos(), "bad conversion: ", err)
+		}
+		lit = constant{int(i), err == nil}
+		if c.ints == nil {
+			c.ints = make(map[*ast.BasicLit]constant)
+		}
+		c.ints[n] = lit
+	}
+	return lit.val, lit.ok
+}
+
+// compPackage compiles the package p. A header declaring the exported
+// functions of any package other than the main package is written to hdr.
+func (c *compiler) compPackage(p *load.Package, hdr io.Writer) {
+	c.pkg = p.Package
 	c.curScope = p.Scope
-	c.compTopScope()
+	main := p.Path == ""
+	switch {
+	case main && !p.Test:
+		c.checkMain()
+	case !main:
+		guard := "CALC_" + BaseName(p.Path) + "_H"
+		fmt.Fprintf(hdr, "#ifndef %s\n#define %s\n", guard, guard)
+		for _, ob := range c.curScope.Objects() {
+			if ob.Kind == ast.Decl && ast.IsExported(ob.Name) {
+				fmt.Fprintf(hdr, "void %s(void);\n", c.symbol(ob.Name))
+			}
+		}
+		fmt.Fprintln(hdr, "#endif")
+	}
+
+	fmt.Fprintln(c.fp, "#include <stdio.h>")
+	if p.Test {
+		fmt.Fprintln(c.fp, "#include <string.h>")
+	}
+	fmt.Fprintln(c.fp, "#include <runtime.h>")
+	for _, imp := range p.Imports {
+		fmt.Fprintf(c.fp, "#include \"%s.h\"\n", BaseName(imp.Path))
+	}
+	if !main {
+		fmt.Fprintf(c.fp, "#include \"%s.h\"\n", BaseName(p.Path))
+	}
+	c.compScopeDecls(main)
+
+	switch {
+	case p.Test:
+		c.compTestMain()
+	case main:
+		fmt.Fprintln(c.fp, "int main(void) {")
+		fmt.Fprintln(c.fp, "stack_init();")
+		fmt.Fprintln(c.fp, "_main();")
+		fmt.Fprintf(c.fp, "printf(\"%%d\\n\", *(int32_t *)eax);\n")
+		fmt.Fprintln(c.fp, "stack_end();")
+		fmt.Fprintln(c.fp, "return 0;")
+		fmt.Fprintln(c.fp, "}")
+	}
 }
 
-func (c *compiler) compScopeDecls() {
-	for k, v := range c.curScope.Table {
-		if v.Kind == ast.Decl {
-			fmt.Fprintf(c.fp, "void _%s(void);\n", k)
-			defer c.compNode(v.Value)
+// compScopeDecls compiles the functions of the current scope. Unless main
+// is true, prototypes are only generated for unexported functions since
+// exported functions are declared in the package header.
+func (c *compiler) compScopeDecls(main bool) {
+	for _, ob := range c.curScope.Objects() {
+		switch {
+		case ob.Kind != ast.Decl:
+		case main:
+			fmt.Fprintf(c.fp, "void %s(void);\n", c.symbol(ob.Name))
+		case !ast.IsExported(ob.Name):
+			fmt.Fprintf(c.fp, "static void %s(void);\n", c.symbol(ob.Name))
+		}
+	}
+	for _, ob := range c.curScope.Objects() {
+		if ob.Kind == ast.Decl {
+			c.compNode(ob.Value)
 		}
 	}
 }
 
-func (c *compiler) compTopScope() {
+func (c *compiler) checkMain() {
 	ob := c.curScope.Lookup("main")
 	switch {
 	case ob == nil:
@@ -372,22 +627,26 @@ func (c *compiler) compTopScope() {
 		c.Error(ob.Type.NamePos, "'main' must be of type but declared as ",
 			ob.Type.Name)
 	}
-	fmt.Fprintln(c.fp, "#include <stdio.h>")
-	fmt.Fprintln(c.fp, "#include <runtime.h>")
-	c.compScopeDecls()
-	fmt.Fprintln(c.fp, "int main(void) {")
-	fmt.Fprintln(c.fp, "stack_init();")
-	fmt.Fprintln(c.fp, "_main();")
-	fmt.Fprintln(c.fp, "printf(\"%d\\n\", *(int32_t *)eax);")
-	fmt.Fprintln(c.fp, "stack_end();")
-	fmt.Fprintln(c.fp, "return 0;")
-	fmt.Fprintln(c.fp, "}")
 }
 
 func (c *compiler) compUnaryExpr(u *ast.UnaryExpr) {
+	if t := typeOf(u.Value, c.curScope); t.Name != "unknown" && !validType(t) {
+		c.Error(u.Value.Pos(), "invalid operand to unary ", u.Op, ": ", t.Name)
+		return
+	}
+	if x, ok := c.compTryOptimizeBinaryOrInt(u); ok {
+		fmt.Fprintf(c.fp, "setl(%d, eax);\n", x)
+		return
+	}
 	c.compNode(u.Value)
-	fmt.Fprintln(c.fp, "setl(-1, edx);")
-	fmt.Fprintln(c.fp, "mull(edx, eax);")
+	switch u.Op {
+	case token.SUB:
+		fmt.Fprintln(c.fp, "negl(eax);")
+	case token.NOT:
+		fmt.Fprintln(c.fp, "lnotl(eax);")
+	case token.XOR:
+		fmt.Fprintln(c.fp, "notl(eax);")
+	}
 }
 
 func (c *compiler) compVarExpr(v *ast.VarExpr) {
@@ -398,7 +657,10 @@ func (c *compiler) compVarExpr(v *ast.VarExpr) {
 			c.compAssignExpr(val)
 			return
 		}
-		panic("parsing error occured, object's Value is not an assignment")
+		// the object was the target of an assignment before its declaration
+		c.Error(v.Name.NamePos, "variable '", v.Name.Name, "' assigned before "+
+			"it is declared")
+		return
 	}
 	// TODO: implement proper zero value code for additional types
 	fmt.Fprintf(c.fp, "setl(0, ebp+%d);\n", ob.Offset)
@@ -424,18 +686,46 @@ func (c *compiler) countVars(n ast.Node) (x int) {
 	return
 }
 
+// compTryOptimizeBinaryOrInt returns the value of e, and true, if it is a
+// constant expression. Each expression is folded once, however many times
+// it is compiled, so that an error such as division by zero is reported
+// once.
 func (c *compiler) compTryOptimizeBinaryOrInt(e ast.Expr) (int, bool) {
+	k, ok := c.folds[e]
+	if !ok {
+		k.val, k.ok = c.fold(e)
+		if c.folds == nil {
+			c.folds = make(map[ast.Expr]constant)
+		}
+		c.folds[e] = k
+	}
+	return k.val, k.ok
+}
+
+// fold evaluates the constant expression e. Like the runtime, arithmetic
+// wraps around at 32 bits.
+func (c *compiler) fold(e ast.Expr) (int, bool) {
 	var ret int
 	var ok bool
 	switch t := e.(type) {
 	case *ast.BasicLit:
 		if t.Kind == token.INTEGER {
-			i, err := strconv.Atoi(t.Lit)
-			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
-			}
-			ret, ok = i, true
+			ret, ok = c.intValue(t)
+		}
+	case *ast.UnaryExpr:
+		var x int
+		if x, ok = c.compTryOptimizeBinaryOrInt(t.Value); !ok {
+			break
+		}
+		switch t.Op {
+		case token.ADD:
+			ret = x
+		case token.SUB:
+			ret = int(-int32(x))
+		case token.NOT:
+			ret = boolToInt(x == 0)
+		case token.XOR:
+			ret = ^x
 		}
 	case *ast.BinaryExpr:
 		for i, v := range t.List {
@@ -450,15 +740,49 @@ func (c *compiler) compTryOptimizeBinaryOrInt(e ast.Expr) (int, bool) {
 			}
 			switch t.Op {
 			case token.ADD:
-				ret += x
+				ret = int(int32(ret + x))
 			case token.SUB:
-				ret -= x
+				ret = int(int32(ret - x))
 			case token.MUL:
-				ret *= x
-			case token.QUO:
-				ret /= x
-			case token.REM:
-				ret %= x
+				ret = int(int32(ret * x))
+			case token.QUO, token.REM:
+				if x == 0 {
+					c.Error(v.Pos(), "integer divide by zero")
+					return 0, false
+				}
+				if t.Op == token.QUO {
+					ret = int(int32(ret / x))
+				} else {
+					ret = int(int32(ret % x))
+				}
+			case token.BAND:
+				ret &= x
+			case token.BOR:
+				ret |= x
+			case token.XOR:
+				ret ^= x
+			case token.ANDNOT:
+				ret &^= x
+			case token.SHL:
+				ret = int(int32(ret) << uint32(x))
+			case token.SHR:
+				ret = int(int32(ret) >> uint32(x))
+			case token.AND:
+				ret = boolToInt(ret >= 1 && x >= 1)
+			case token.OR:
+				ret = boolToInt(ret >= 1 || x >= 1)
+			case token.EQL:
+				ret = boolToInt(ret == x)
+			case token.NEQ:
+				ret = boolToInt(ret != x)
+			case token.LST:
+				ret = boolToInt(ret < x)
+			case token.LTE:
+				ret = boolToInt(ret <= x)
+			case token.GTT:
+				ret = boolToInt(ret > x)
+			case token.GTE:
+				ret = boolToInt(ret >= x)
 			default:
 				return 0, false
 			}
@@ -467,6 +791,14 @@ func (c *compiler) compTryOptimizeBinaryOrInt(e ast.Expr) (int, bool) {
 	return ret, ok
 }
 
+// boolToInt returns the value of b as the runtime represents it
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 func (c *compiler) matchTypes(a, b ast.Node) {
 	atype, btype := typeOf(a, c.curScope), typeOf(b, c.curScope)
 
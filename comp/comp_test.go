@@ -8,15 +8,23 @@
 package comp_test
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 
+	"github.com/rthornton128/calc/ast"
 	"github.com/rthornton128/calc/comp"
+	"github.com/rthornton128/calc/interp"
+	"github.com/rthornton128/calc/load"
+	"github.com/rthornton128/calc/parse"
+	"github.com/rthornton128/calc/token"
 )
 
 var ext string
@@ -45,6 +53,351 @@ func TestVarExpression(t *testing.T) {
 	test_handler(t, "(decl main int ((var (= a 5)) a))", "5")
 }
 
+func TestNumericLiterals(t *testing.T) {
+	test_handler(t, "(decl main int (+ 0x10 0o10 0b10 1_000))", "1026")
+	test_handler(t, "(decl main int ((var (= a 0xFf)) (+ a 0B1)))", "256")
+}
+
+func TestBitwiseExpression(t *testing.T) {
+	test_handler(t, "(decl main int (+ (<< 1 4) (& 0xff 0x0f) (>> 64 2)))", "47")
+	test_handler(t, "(decl main int ((var (= a 12)) (+ (& a 10) (| a 1) "+
+		"(^ a 5) (&^ a 10) (<< a 2) (>> (- 0 a) 1) ^a)))", "63")
+}
+
+func TestUnaryExpression(t *testing.T) {
+	test_handler(t, "(decl main int (+ -3 +4 !0 !5 ^0 not 0))", "2")
+	test_handler(t, "(decl main int ((var (= a 5)) (+ -a +a !a ^a !(== a 0))))",
+		"-5")
+}
+
+func TestAssert(t *testing.T) {
+	test_handler(t, "(decl main int ((assert (== 2 2) \"ok\") 7))", "7")
+	test_handler(t, "(decl main int ((assert (< 2 1) \"no\") 7))",
+		"test.calc:1:17 assertion failed: no")
+}
+
+func TestReproducibleOutput(t *testing.T) {
+	defer tearDown()
+
+	src := "(decl main int (d (c (b (a 1)))))\n"
+	for _, name := range []string{"d", "c", "b", "a"} {
+		src += "(decl " + name + " (n int) int (+ n 1))\n"
+	}
+	err := ioutil.WriteFile("test.calc", []byte(src), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("test.calc")
+
+	var first []byte
+	for i := 0; i < 10; i++ {
+		if err := comp.CompileFile("test.calc"); err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadFile("test.c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = out
+			continue
+		}
+		if string(out) != string(first) {
+			t.Fatal("Output differs between runs:\n", string(first), "\n",
+				string(out))
+		}
+	}
+
+	protos := "void _main(void);\nvoid _d(void);\nvoid _c(void);\n" +
+		"void _b(void);\nvoid _a(void);\n"
+	if !strings.Contains(string(first), protos) {
+		t.Fatal("Expected declarations in source order, got:\n", string(first))
+	}
+}
+
+func TestImport(t *testing.T) {
+	defer tearDown()
+
+	dir := writeFiles(t, map[string]string{
+		"main.calc": "(import \"geo/shapes\")\n(import \"num\")\n" +
+			"(decl main int (+ (shapes.Area 3 4) (num.Double 1) (double 10)))\n" +
+			"(decl double (n int) int (+ n n))",
+		"geo/shapes/shapes.calc": "(import \"num\")\n" +
+			"(decl Area (w h int) int (num.Mul w h))",
+		"num/num.calc": "(decl Double (n int) int (double n))\n" +
+			"(decl Mul (a b int) int (* a b))\n(decl double (n int) int (* n 2))",
+	})
+	defer os.RemoveAll(dir)
+
+	if err := comp.CompileDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	hdr, err := ioutil.ReadFile(filepath.Join(dir, "num.h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "#ifndef CALC_num_H\n#define CALC_num_H\nvoid _num__Double(void);\n" +
+		"void _num__Mul(void);\n#endif\n"
+	if string(hdr) != exp {
+		t.Fatalf("Expected: %q Got: %q", exp, hdr)
+	}
+	out, err := ioutil.ReadFile(filepath.Join(dir, "num.c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "static void _num__double(void);") ||
+		strings.Contains(string(out), "int main(void)") {
+		t.Fatal("Expected: static double and no main Got:\n", string(out))
+	}
+
+	var csrc []string
+	for _, name := range []string{"num", "geo_sshapes", filepath.Base(dir)} {
+		csrc = append(csrc, filepath.Join(dir, name+".c"))
+	}
+	if output := build_and_run(t, csrc...); output != "34" {
+		t.Fatal("Expected: 34 Got:", output)
+	}
+
+	src := "(import \"num\")\n(decl main int (num.double 2))"
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.calc"), []byte(src),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+	err = comp.CompileFile(filepath.Join(dir, "main.calc"))
+	if err == nil || !strings.Contains(err.Error(),
+		"main.calc:2:17 cannot refer to unexported name 'num.double'") {
+		t.Fatal("Expected: unexported name error Got:", err)
+	}
+}
+
+func TestBadConversion(t *testing.T) {
+	for _, src := range []string{
+		"(decl main int 99999999999)",
+		"(decl main int (+ 1 99999999999))",
+		"(decl main int (* 2 (+ 1 -99999999999)))",
+		"(decl main int ((var (= a 1)) (+ a 99999999999)))",
+	} {
+		fset := token.NewFileSet()
+		f, err := parse.ParseSource(fset, "test.calc", src, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = comp.CheckFile(fset, f)
+		list, ok := err.(token.ErrorList)
+		if !ok || list.Count() != 1 ||
+			!strings.Contains(list[0].Msg(), "bad conversion") {
+			t.Fatalf("For %s expected: one bad conversion Got: %v", src, err)
+		}
+	}
+}
+
+func TestDivideByZero(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parse.ParseSource(fset, "test.calc",
+		"(decl main int (+ 1 (* 2 (/ 1 0))))", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = comp.CheckFile(fset, f)
+	if list, ok := err.(token.ErrorList); !ok || list.Count() != 1 ||
+		list[0].Error() != "test.calc:1:31 integer divide by zero" {
+		t.Fatal("Expected: one divide by zero error Got:", err)
+	}
+}
+
+// TestOverflow checks that folded constants wrap around like the
+// interpreter does
+func TestOverflow(t *testing.T) {
+	for _, src := range []string{
+		"(* 65536 65536)",
+		"(+ 2147483647 1)",
+		"(- -2147483647 2)",
+		"(* 3 (+ 2147483647 1) -1)",
+		"(/ (- -2147483647 1) -1)",
+		"(% (- -2147483647 1) -1)",
+		"(+ (<< 1 31) (<< 1 31))",
+	} {
+		fset := token.NewFileSet()
+		list, err := parse.ParseInput(fset, "input", src, ast.NewScope(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := interp.New(fset).Eval(list[0], ast.NewScope(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		test_handler(t, "(decl main int "+src+")", strconv.Itoa(int(v)))
+	}
+}
+
+func TestFoldComparison(t *testing.T) {
+	var tests = []struct {
+		src string
+		val int
+	}{
+		{"(< 1 2)", 1},
+		{"(<= 2 1)", 0},
+		{"(> 3 2 0)", 1},
+		{"(>= 2 2)", 1},
+		{"(== 3 3 1)", 1},
+		{"(!= 1 1)", 0},
+		{"(&& 1 2)", 1},
+		{"(&& 1 0)", 0},
+		{"(|| 0 -1)", 0},
+		{"(|| 0 1)", 1},
+		{"(&& (< 1 2) (!= 1 2))", 1},
+	}
+	for _, v := range tests {
+		src := "(decl main int " + v.src + ")"
+		fset := token.NewFileSet()
+		f, err := parse.ParseSource(fset, "test.calc", src, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg := load.Package{Package: &ast.Package{Name: "main",
+			Scope: f.Scope, Files: []*ast.File{f}}}
+		var buf bytes.Buffer
+		if err := comp.CompilePackage(&buf, ioutil.Discard, fset, &pkg); err != nil {
+			t.Fatal(err)
+		}
+		if exp := fmt.Sprintf("setl(%d, eax);", v.val); !strings.Contains(
+			buf.String(), exp) || strings.Contains(buf.String(), "edx") {
+			t.Fatalf("For %s expected: %s Got:\n%s", v.src, exp, buf.String())
+		}
+		test_handler(t, src, strconv.Itoa(v.val))
+	}
+}
+
+func TestBaseName(t *testing.T) {
+	var tests = []struct {
+		path, name string
+	}{
+		{"num", "num"},
+		{"geo/shapes", "geo_sshapes"},
+		{"geo_shapes", "geo_ushapes"},
+		{"geo.shapes", "geo_x2eshapes"},
+		{"a/b_sc", "a_sb_usc"},
+		{"a_sb/sc", "a_usb_ssc"},
+	}
+	for _, v := range tests {
+		if name := comp.BaseName(v.path); name != v.name {
+			t.Fatal("Expected:", v.name, "Got:", name)
+		}
+	}
+}
+
+// TestSharedBaseName builds a program importing two packages named util
+// from different files so that each declares a function of the same name
+func TestSharedBaseName(t *testing.T) {
+	defer tearDown()
+
+	dir := writeFiles(t, map[string]string{
+		"main.calc":     "(import \"x/util\")\n(decl main int (+ (util.Get) (get)))",
+		"get.calc":      "(import \"y/util\")\n(decl get int (util.Get))",
+		"x/util/x.calc": "(decl Get int 1)",
+		"y/util/y.calc": "(decl Get int 20)",
+	})
+	defer os.RemoveAll(dir)
+
+	if err := comp.CompileDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	var csrc []string
+	for _, name := range []string{comp.BaseName("x/util"),
+		comp.BaseName("y/util"), filepath.Base(dir)} {
+		csrc = append(csrc, filepath.Join(dir, name+".c"))
+	}
+	if output := build_and_run(t, csrc...); output != "21" {
+		t.Fatal("Expected: 21 Got:", output)
+	}
+}
+
+func TestTests(t *testing.T) {
+	defer tearDown()
+
+	dir := writeFiles(t, map[string]string{
+		"add.calc": "(decl add (a b int) int (+ a b))",
+		"add_test.calc": "(decl testAdd int (assert (== (add 2 3) 5) \"2+3\"))\n" +
+			"(decl testFail int ((assert (== (add 2 2) 5) \"2+2 is \\\"5\\\"?\") 1))\n" +
+			"(decl testZero int (- (add 1 1) 2))\n(decl tester int 0)",
+	})
+	defer os.RemoveAll(dir)
+
+	fset := token.NewFileSet()
+	pkg, err := load.New(fset, []string{dir}).LoadTest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrc, err := comp.CompileProgram(dir, "add", fset, pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "add_test.calc:2:21 assertion failed: 2+2 is \"5\"?\n" +
+		"--- FAIL: testFail (add_test.calc:2:7)\n" +
+		"add_test.calc:3:7 testZero returned 0\n" +
+		"--- FAIL: testZero (add_test.calc:3:7)\nFAIL"
+	if output := build_and_run(t, csrc...); output != exp {
+		t.Fatalf("Expected: %q Got: %q", exp, output)
+	}
+
+	src := "(decl testArgs (n int) int n)\n(decl testBool bool testArgs)"
+	if err := ioutil.WriteFile(filepath.Join(dir, "add_test.calc"), []byte(src),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err = load.New(fset, []string{dir}).LoadTest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = comp.CompileProgram(dir, "add", fset, pkg)
+	for _, exp := range []string{
+		"add_test.calc:1:7 test function 'testArgs' must not have parameters",
+		"add_test.calc:2:7 test function 'testBool' must be of type int",
+	} {
+		if err == nil || !strings.Contains(err.Error(), exp) {
+			t.Fatalf("Expected: %q Got: %q", exp, err)
+		}
+	}
+}
+
+// FuzzCompile checks that compiling any input which parses, as a program,
+// as an imported package and as the tests of a package, and type checking
+// it reports errors rather than panicking. The seed corpus is the examples.
+func FuzzCompile(f *testing.F) {
+	addExamples(f)
+	// each of these once panicked
+	f.Add("(decl main int (/ 1 (- 2 2)))")
+	f.Add("(decl f int 1)\n(decl main int ((= f 2) (f)))")
+	f.Add("(decl main int ((= a 1) (var (= a 2)) a))")
+	f.Fuzz(func(t *testing.T, src string) {
+		for _, pkg := range []load.Package{
+			{Package: &ast.Package{Name: "main"}},
+			{Package: &ast.Package{Name: "fuzz", Path: "fuzz"}},
+			{Package: &ast.Package{Name: "main"}, Test: true},
+		} {
+			// compiling records types in the objects of the scope, so each
+			// is given a freshly parsed file
+			fset := token.NewFileSet()
+			file, err := parse.ParseSource(fset, "fuzz_test.calc", src, nil)
+			if err != nil {
+				return
+			}
+			pkg.Scope, pkg.Files = file.Scope, []*ast.File{file}
+			err = comp.CompilePackage(ioutil.Discard, ioutil.Discard, fset, &pkg)
+			if _, ok := err.(token.ErrorList); err != nil && !ok {
+				t.Fatal("Expected: token.ErrorList Got:", err)
+			}
+		}
+
+		fset := token.NewFileSet()
+		file, _ := parse.ParseSource(fset, "fuzz.calc", src, nil)
+		err := comp.CheckFile(fset, file)
+		if _, ok := err.(token.ErrorList); err != nil && !ok {
+			t.Fatal("Expected: token.ErrorList Got:", err)
+		}
+	})
+}
+
 func test_handler(t *testing.T, src, expected string) {
 	defer tearDown()
 
@@ -55,11 +408,43 @@ func test_handler(t *testing.T, src, expected string) {
 	comp.CompileFile("test.calc")
 	os.Remove("test.calc")
 
+	output := build_and_run(t, "test.c")
+	t.Log("len output:", len(output))
+	t.Log("len expected:", len(expected))
+
+	if output != expected {
+		t.Fatal("For " + src + " expected " + expected + " got " + output)
+	}
+}
+
+// build_and_run compiles the C source files csrc to the executable test and
+// returns its output with surrounding white space removed
+// writeFiles creates the files, keyed by slash separated path, in a new
+// temporary directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func build_and_run(t *testing.T, csrc ...string) string {
 	runpath, _ := filepath.Abs("../runtime")
 	runlib := filepath.Join(runpath, "runtime.a")
-	out, err := exec.Command("gcc"+ext, "-Wall", "-Wextra", "-std=c99",
-		"-I", runpath, "--output=test"+ext,
-		"test.c", runlib).CombinedOutput()
+	args := []string{"-Wall", "-Wextra", "-Werror", "-std=c99", "-I", runpath,
+		"--output=test" + ext}
+	args = append(append(args, csrc...), runlib)
+	out, err := exec.Command("gcc"+ext, args...).CombinedOutput()
 	if err != nil {
 		t.Log(string(out))
 		t.Fatal(err)
@@ -72,16 +457,29 @@ func test_handler(t *testing.T, src, expected string) {
 	default:
 		output, err = exec.Command("./test").Output()
 	}
-	output = []byte(strings.TrimSpace(string(output)))
-	t.Log("len output:", len(output))
-	t.Log("len expected:", len(expected))
-
-	if string(output) != expected {
-		t.Fatal("For " + src + " expected " + expected + " got " + string(output))
-	}
+	return strings.TrimSpace(string(output))
 }
 
 func tearDown() {
 	os.Remove("test.c")
 	os.Remove("test" + ext)
 }
+
+// addExamples adds the source of every file in the examples directory to
+// the seed corpus of f
+func addExamples(f *testing.F) {
+	err := filepath.Walk(filepath.Join("..", "examples"),
+		func(path string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() || filepath.Ext(path) != ".calc" {
+				return err
+			}
+			src, err := ioutil.ReadFile(path)
+			if err == nil {
+				f.Add(string(src))
+			}
+			return err
+		})
+	if err != nil {
+		f.Fatal(err)
+	}
+}
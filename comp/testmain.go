@@ -0,0 +1,151 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package comp
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rthornton128/calc/ast"
+	"github.com/rthornton128/calc/parse"
+	"github.com/rthornton128/calc/token"
+)
+
+// isTestName reports whether name is the name of a test function: test
+// followed by nothing or a character that is not a lower-case letter, like
+// testAddition or test_add
+func isTestName(name string) bool {
+	if !strings.HasPrefix(name, "test") {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(name[len("test"):])
+	return r == utf8.RuneError || !unicode.IsLower(r)
+}
+
+// tests returns the test functions declared in the test files of the
+// current package, in the order they were declared
+func (c *compiler) tests() []*ast.Object {
+	var list []*ast.Object
+	for _, ob := range c.curScope.Objects() {
+		if ob.Kind != ast.Decl || !isTestName(ob.Name) ||
+			!parse.IsTestFile(c.fset.Position(ob.NamePos).Filename) {
+			continue
+		}
+		decl := ob.Value.(*ast.DeclExpr)
+		switch {
+		case len(decl.Params) != 0:
+			c.Error(ob.NamePos, "test function '", ob.Name, "' must not have "+
+				"parameters")
+		case ob.Type == nil || ob.Type.Name != "int":
+			c.Error(ob.NamePos, "test function '", ob.Name, "' must be of "+
+				"type int")
+		default:
+			list = append(list, ob)
+		}
+	}
+	return list
+}
+
+// compTestMain generates a main function which runs each test of the
+// current package, reports those that fail and exits with a non-zero
+// status if any did. The tests are listed as they run if the program's
+// first argument is -v.
+func (c *compiler) compTestMain() {
+	tests := c.tests()
+
+	fmt.Fprintln(c.fp, "int main(int argc, char *argv[]) {")
+	fmt.Fprintln(c.fp, "int verbose = argc > 1 && strcmp(argv[1], \"-v\") == 0;")
+	fmt.Fprintln(c.fp, "int failed = 0;")
+	fmt.Fprintln(c.fp, "stack_init();")
+	for _, ob := range tests {
+		fmt.Fprintf(c.fp, "failed += test_run(%s, %s, %s, verbose);\n",
+			c.symbol(ob.Name), cString(ob.Name), cString(c.position(ob.NamePos)))
+	}
+	if len(tests) == 0 {
+		fmt.Fprintln(c.fp, "printf(\"testing: no tests to run\\n\");")
+	}
+	fmt.Fprintln(c.fp, "stack_end();")
+	fmt.Fprintln(c.fp, "if (failed != 0) {")
+	fmt.Fprintln(c.fp, "printf(\"FAIL\\n\");")
+	fmt.Fprintln(c.fp, "return 1;")
+	fmt.Fprintln(c.fp, "}")
+	fmt.Fprintln(c.fp, "printf(\"PASS\\n\");")
+	fmt.Fprintln(c.fp, "return 0;")
+	fmt.Fprintln(c.fp, "}")
+}
+
+// compAssert compiles a call to the builtin assert, which takes a condition
+// of type int and an optional string literal describing it. A condition of
+// zero is reported, along with the position of the call, when the program
+// runs. The value of the call is the condition.
+func (c *compiler) compAssert(e *ast.CallExpr) {
+	if len(e.Args) < 1 || len(e.Args) > 2 {
+		c.Error(e.Name.NamePos, "assert takes a condition and an optional "+
+			"message, got ", len(e.Args), " arguments")
+		return
+	}
+	if t := typeOf(e.Args[0], c.curScope); t.Name != "int" {
+		c.Error(e.Args[0].Pos(), "assert condition must be of type int, got ",
+			t.Name)
+	}
+
+	msg := ""
+	if len(e.Args) == 2 {
+		lit, ok := e.Args[1].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			c.Error(e.Args[1].Pos(), "assert message must be a string literal")
+			return
+		}
+		var err error
+		if msg, err = strconv.Unquote(lit.Lit); err != nil {
+			c.Error(lit.Pos(), "bad string literal: ", err)
+			return
+		}
+	}
+
+	c.compNode(e.Args[0])
+	fmt.Fprintf(c.fp, "assertl(eax, %s, %s);\n", cString(c.position(e.Pos())),
+		cString(msg))
+}
+
+// position returns pos as reported by a running program. The file name is
+// relative to the package directory and, for an imported package, prefixed
+// by its import path.
+func (c *compiler) position(pos token.Pos) string {
+	p := c.fset.Position(pos)
+	p.Filename = filepath.Base(p.Filename)
+	if c.pkg != nil && c.pkg.Path != "" {
+		p.Filename = path.Join(c.pkg.Path, p.Filename)
+	}
+	return p.String()
+}
+
+// cString returns s as a C string literal. Anything but printable ASCII is
+// written as an octal escape, as is ? so that no trigraph is formed.
+func cString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '"' || ch == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case ch < ' ' || ch > '~' || ch == '?':
+			fmt.Fprintf(&b, "\\%03o", ch)
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -5,6 +5,12 @@ import (
 	"github.com/rthornton128/calc/token"
 )
 
+// TypeOf returns the type of the expression n, evaluated within scope s.
+// The name of the returned type is "unknown" if it can not be determined.
+func TypeOf(n ast.Node, s *ast.Scope) *ast.Ident {
+	return typeOf(n, s)
+}
+
 func validType(t *ast.Ident) bool {
 	return t.Name == "int"
 }
@@ -12,21 +18,26 @@ func validType(t *ast.Ident) bool {
 func typeOf(n ast.Node, s *ast.Scope) (t *ast.Ident) {
 	switch e := n.(type) {
 	case *ast.AssignExpr:
-		t = typeOfObject(s.Lookup(e.Name.Name))
+		t = typeOfObject(s.Resolve(e.Name.Name))
 	case *ast.BasicLit:
 		t = typeOfBasic(e)
 	case *ast.BinaryExpr:
 		t = typeOf(e.List[0], s)
 	case *ast.CallExpr:
-		t = typeOfObject(s.Lookup(e.Name.Name))
+		ob := s.Resolve(e.Name.Name)
+		if ob == nil && e.Name.Name == "assert" {
+			t = &ast.Ident{Name: "int", NamePos: e.Pos()}
+			break
+		}
+		t = typeOfObject(ob)
 	case *ast.DeclExpr:
-		t = typeOfObject(s.Lookup(e.Name.Name))
+		t = typeOfObject(s.Resolve(e.Name.Name))
 	case *ast.ExprList:
 		// BUG: should perform exit analysis to make sure all return
 		// values match return type
 		t = typeOf(e.List[len(e.List)-1], s)
 	case *ast.Ident:
-		t = typeOfObject(s.Lookup(e.Name))
+		t = typeOfObject(s.Resolve(e.Name))
 	case *ast.IfExpr:
 		if e.Type != nil {
 			t = e.Type
@@ -0,0 +1,89 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+// Package doc extracts documentation for the top-level function
+// declarations of Calc source code
+package doc
+
+import (
+	"strings"
+
+	"github.com/rthornton128/calc/ast"
+	"github.com/rthornton128/calc/token"
+)
+
+// Package is the documentation for a single file or package directory
+type Package struct {
+	Name  string
+	Funcs []*Func
+}
+
+// Func is the documentation for a top-level function declaration
+type Func struct {
+	Name     string
+	Params   []*Param
+	Type     string // return type
+	Doc      string // leading comment text, may be empty
+	Position token.Position
+}
+
+// Param is a single parameter of a function
+type Param struct {
+	Name string
+	Type string
+}
+
+// New returns the documentation for the functions declared in scope,
+// which is normally the Scope of an ast.File or ast.Package. Functions are
+// in the order they were declared.
+func New(fset *token.FileSet, name string, scope *ast.Scope) *Package {
+	pkg := &Package{Name: name}
+	for _, ob := range scope.Objects() {
+		if d, ok := ob.Value.(*ast.DeclExpr); ok && ob.Kind == ast.Decl {
+			pkg.Funcs = append(pkg.Funcs, NewFunc(fset, d))
+		}
+	}
+	return pkg
+}
+
+// NewFunc returns the documentation for the function declaration d
+func NewFunc(fset *token.FileSet, d *ast.DeclExpr) *Func {
+	f := &Func{
+		Name:     d.Name.Name,
+		Doc:      d.Doc.Text(),
+		Position: fset.Position(d.Name.NamePos),
+	}
+	if d.Type != nil {
+		f.Type = d.Type.Name
+	}
+	for _, p := range d.Params {
+		param := &Param{Name: p.Name}
+		if p.Object != nil && p.Object.Type != nil {
+			param.Type = p.Object.Type.Name
+		}
+		f.Params = append(f.Params, param)
+	}
+	return f
+}
+
+// Signature returns the declaration of f as it would be written in Calc
+// source code without its body, such as "(decl add (a b int) int)".
+// Consecutive parameters of the same type are grouped together.
+func (f *Func) Signature() string {
+	sig := "(decl " + f.Name
+	if len(f.Params) > 0 {
+		var groups []string
+		for i, p := range f.Params {
+			groups = append(groups, p.Name)
+			if i == len(f.Params)-1 || f.Params[i+1].Type != p.Type {
+				groups[len(groups)-1] += " " + p.Type
+			}
+		}
+		sig += " (" + strings.Join(groups, " ") + ")"
+	}
+	return sig + " " + f.Type + ")"
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package doc_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rthornton128/calc/doc"
+	"github.com/rthornton128/calc/parse"
+	"github.com/rthornton128/calc/token"
+)
+
+func TestPackage(t *testing.T) {
+	fset := token.NewFileSet()
+	pkg, err := parse.ParseDir(fset, "../examples/package")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := doc.New(fset, "package", pkg.Scope)
+	if len(p.Funcs) != 2 {
+		t.Fatal("Expected: 2 functions Got:", len(p.Funcs))
+	}
+
+	add := p.Funcs[0]
+	if add.Name != "add" {
+		t.Fatal("Expected: add Got:", add.Name)
+	}
+	if sig := add.Signature(); sig != "(decl add (a b int) int)" {
+		t.Fatal("Expected: (decl add (a b int) int) Got:", sig)
+	}
+	if add.Doc != "add returns the sum of a and b\n" {
+		t.Fatalf("Expected: doc comment Got: %q", add.Doc)
+	}
+	if sig := p.Funcs[1].Signature(); sig != "(decl main int)" {
+		t.Fatal("Expected: (decl main int) Got:", sig)
+	}
+
+	var buf bytes.Buffer
+	if err := p.Markdown(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "## add\n\n    (decl add (a b int) int)\n\n"+
+		"add returns the sum of a and b\n") {
+		t.Fatal("Unexpected markdown:\n", buf.String())
+	}
+
+	buf.Reset()
+	if err := p.HTML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<p>add returns the sum of a and b</p>") {
+		t.Fatal("Unexpected HTML:\n", buf.String())
+	}
+}
+
+func TestSignature(t *testing.T) {
+	f := &doc.Func{
+		Name: "mix",
+		Type: "int",
+		Params: []*doc.Param{
+			{Name: "a", Type: "int"},
+			{Name: "b", Type: "bool"},
+			{Name: "c", Type: "bool"},
+		},
+	}
+	if sig := f.Signature(); sig != "(decl mix (a int b c bool) int)" {
+		t.Fatal("Expected: (decl mix (a int b c bool) int) Got:", sig)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package doc
+
+import (
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	"text/template"
+)
+
+var funcs = map[string]interface{}{
+	"paragraphs": paragraphs,
+}
+
+var mdTemplate = template.Must(template.New("markdown").Funcs(funcs).Parse(
+	`# {{.Name}}
+{{range .Funcs}}
+## {{.Name}}
+
+    {{.Signature}}
+{{range paragraphs .Doc}}
+{{.}}
+{{end}}{{end}}`))
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(funcs).Parse(
+	`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+{{range .Funcs}}
+<h2 id="{{.Name}}">{{.Name}}</h2>
+<pre>{{.Signature}}</pre>
+{{range paragraphs .Doc}}<p>{{.}}</p>
+{{end}}{{end}}</body>
+</html>
+`))
+
+// Markdown writes the documentation for p to w in Markdown format
+func (p *Package) Markdown(w io.Writer) error {
+	return mdTemplate.Execute(w, p)
+}
+
+// HTML writes the documentation for p to w as a stand-alone HTML page
+func (p *Package) HTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, p)
+}
+
+// paragraphs splits comment text into paragraphs separated by blank lines
+func paragraphs(text string) []string {
+	var paras []string
+	for _, para := range strings.Split(text, "\n\n") {
+		if para = strings.TrimSpace(para); para != "" {
+			paras = append(paras, para)
+		}
+	}
+	return paras
+}
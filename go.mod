@@ -0,0 +1,3 @@
+module github.com/rthornton128/calc
+
+go 1.18
@@ -0,0 +1,312 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+// Package interp implements a tree-walking interpreter for Calc. Values
+// are 32-bit integers and behave like those of compiled programs.
+package interp
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/rthornton128/calc/ast"
+	"github.com/rthornton128/calc/comp"
+	"github.com/rthornton128/calc/token"
+)
+
+// MaxDepth is the maximum depth of nested function calls before evaluation
+// is aborted with a stack overflow
+const MaxDepth = 10000
+
+// Interp evaluates Calc expressions. Top-level variables persist between
+// calls to Eval.
+type Interp struct {
+	fset    *token.FileSet
+	globals map[*ast.Object]int32
+	frame   map[*ast.Object]int32 // local variables, nil at top-level
+	scope   *ast.Scope
+	depth   int
+}
+
+// Error is a run-time error
+type Error struct {
+	Pos token.Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprint(e.Pos, " ", e.Msg)
+}
+
+// bailout is used to unwind the stack when a run-time error occurs
+type bailout struct {
+	err *Error
+}
+
+// New returns a new interpreter. The file set is used to report positions
+// of run-time errors.
+func New(fset *token.FileSet) *Interp {
+	return &Interp{fset: fset, globals: make(map[*ast.Object]int32)}
+}
+
+// Eval evaluates the expression e within scope s and returns its value.
+// Declarations evaluate to zero.
+func (in *Interp) Eval(e ast.Expr, s *ast.Scope) (v int32, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b, ok := r.(bailout)
+			if !ok {
+				panic(r)
+			}
+			in.frame, in.depth = nil, 0
+			err = b.err
+		}
+	}()
+
+	in.scope = s
+	return in.eval(e), nil
+}
+
+func (in *Interp) error(pos token.Pos, args ...interface{}) {
+	var p token.Position
+	if pos.Valid() {
+		p = in.fset.Position(pos)
+	}
+	panic(bailout{&Error{Pos: p, Msg: fmt.Sprint(args...)}})
+}
+
+func (in *Interp) lookup(id *ast.Ident) *ast.Object {
+	ob := in.scope.Lookup(id.Name)
+	if ob == nil {
+		in.error(id.NamePos, "undeclared identifier '", id.Name, "'")
+	}
+	return ob
+}
+
+func (in *Interp) load(ob *ast.Object) int32 {
+	if v, ok := in.frame[ob]; ok {
+		return v
+	}
+	return in.globals[ob]
+}
+
+func (in *Interp) store(ob *ast.Object, v int32) {
+	if _, ok := in.frame[ob]; ok {
+		in.frame[ob] = v
+		return
+	}
+	in.globals[ob] = v
+}
+
+func (in *Interp) eval(e ast.Expr) int32 {
+	if e == nil || reflect.ValueOf(e).IsNil() {
+		return 0
+	}
+
+	switch n := e.(type) {
+	case *ast.AssignExpr:
+		ob := in.lookup(n.Name)
+		v := in.eval(n.Value)
+		if ob.Type == nil {
+			ob.Type = comp.TypeOf(n.Value, in.scope)
+		}
+		in.store(ob, v)
+		return v
+	case *ast.BasicLit:
+		if n.Kind != token.INTEGER {
+			in.error(n.LitPos, "string literal may only be used as the message "+
+				"of assert")
+		}
+		i, err := strconv.ParseInt(n.Lit, 0, 32)
+		if err != nil {
+			in.error(n.LitPos, "bad conversion: ", err)
+		}
+		return int32(i)
+	case *ast.BinaryExpr:
+		return in.evalBinary(n)
+	case *ast.CallExpr:
+		return in.evalCall(n)
+	case *ast.DeclExpr:
+		return 0
+	case *ast.ExprList:
+		var v int32
+		for _, x := range n.List {
+			v = in.eval(x)
+		}
+		return v
+	case *ast.Ident:
+		ob := in.lookup(n)
+		if ob.Kind != ast.Var {
+			in.error(n.NamePos, "'", n.Name, "' is not a variable")
+		}
+		return in.load(ob)
+	case *ast.ImportExpr:
+		in.error(n.Import, "import is not supported by the interpreter")
+	case *ast.IfExpr:
+		cond := in.eval(n.Cond)
+		saved := in.scope
+		in.scope = n.Scope
+		defer func() { in.scope = saved }()
+
+		// like compiled code, only a value of exactly one is true and the
+		// condition itself is the result if there is no else clause
+		switch {
+		case cond == 1:
+			return in.eval(n.Then)
+		case n.Else != nil:
+			return in.eval(n.Else)
+		}
+		return cond
+	case *ast.UnaryExpr:
+		x := in.eval(n.Value)
+		switch n.Op {
+		case token.SUB:
+			x = -x
+		case token.NOT:
+			x = boolToInt(x == 0)
+		case token.XOR:
+			x = ^x
+		}
+		return x
+	case *ast.VarExpr:
+		var v int32
+		if in.frame != nil {
+			in.frame[n.Object] = 0
+		} else {
+			in.globals[n.Object] = 0
+		}
+		if a, ok := n.Object.Value.(*ast.AssignExpr); ok && a != nil {
+			v = in.eval(a)
+		}
+		return v
+	}
+	in.error(e.Pos(), "unable to evaluate expression")
+	return 0
+}
+
+func (in *Interp) evalBinary(b *ast.BinaryExpr) int32 {
+	x := in.eval(b.List[0])
+	for _, e := range b.List[1:] {
+		y := in.eval(e)
+		switch b.Op {
+		case token.ADD:
+			x += y
+		case token.SUB:
+			x -= y
+		case token.MUL:
+			x *= y
+		case token.QUO, token.REM:
+			if y == 0 {
+				in.error(e.Pos(), "integer divide by zero")
+			}
+			if b.Op == token.QUO {
+				x /= y
+			} else {
+				x %= y
+			}
+		case token.AND:
+			x = boolToInt(x >= 1 && y >= 1)
+		case token.OR:
+			x = boolToInt(x >= 1 || y >= 1)
+		case token.EQL:
+			x = boolToInt(x == y)
+		case token.NEQ:
+			x = boolToInt(x != y)
+		case token.LST:
+			x = boolToInt(x < y)
+		case token.LTE:
+			x = boolToInt(x <= y)
+		case token.GTT:
+			x = boolToInt(x > y)
+		case token.GTE:
+			x = boolToInt(x >= y)
+		case token.BAND:
+			x &= y
+		case token.BOR:
+			x |= y
+		case token.XOR:
+			x ^= y
+		case token.ANDNOT:
+			x &^= y
+		case token.SHL:
+			x <<= uint32(y)
+		case token.SHR:
+			x >>= uint32(y)
+		default:
+			in.error(b.OpPos, "unknown operator ", b.Op)
+		}
+	}
+	return x
+}
+
+func (in *Interp) evalCall(c *ast.CallExpr) int32 {
+	if c.Name.Name == "assert" && in.scope.Lookup(c.Name.Name) == nil {
+		return in.evalAssert(c)
+	}
+	ob := in.lookup(c.Name)
+	decl, ok := ob.Value.(*ast.DeclExpr)
+	if ob.Kind != ast.Decl || !ok {
+		in.error(c.Name.NamePos, "may not call object that is not a function")
+	}
+	if len(decl.Params) != len(c.Args) {
+		in.error(c.Name.NamePos, "number of arguments in function call do not "+
+			"match declaration, expected ", len(decl.Params), " got ", len(c.Args))
+	}
+	if in.depth >= MaxDepth {
+		in.error(c.Name.NamePos, "stack overflow")
+	}
+
+	frame := make(map[*ast.Object]int32, len(decl.Params))
+	for i, arg := range c.Args {
+		frame[decl.Params[i].Object] = in.eval(arg)
+	}
+
+	savedFrame, savedScope := in.frame, in.scope
+	in.frame, in.scope = frame, decl.Scope
+	in.depth++
+	v := in.eval(decl.Body)
+	in.depth--
+	in.frame, in.scope = savedFrame, savedScope
+	return v
+}
+
+// evalAssert evaluates a call to the builtin assert, which fails with a
+// run-time error if its condition is zero
+func (in *Interp) evalAssert(c *ast.CallExpr) int32 {
+	if len(c.Args) < 1 || len(c.Args) > 2 {
+		in.error(c.Name.NamePos, "assert takes a condition and an optional "+
+			"message, got ", len(c.Args), " arguments")
+	}
+	msg := ""
+	if len(c.Args) == 2 {
+		lit, ok := c.Args[1].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			in.error(c.Args[1].Pos(), "assert message must be a string literal")
+		}
+		var err error
+		if msg, err = strconv.Unquote(lit.Lit); err != nil {
+			in.error(lit.LitPos, "bad string literal: ", err)
+		}
+	}
+
+	v := in.eval(c.Args[0])
+	if v == 0 {
+		if msg == "" {
+			in.error(c.Pos(), "assertion failed")
+		}
+		in.error(c.Pos(), "assertion failed: ", msg)
+	}
+	return v
+}
+
+func boolToInt(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
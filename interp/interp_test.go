@@ -0,0 +1,122 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package interp_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rthornton128/calc/ast"
+	"github.com/rthornton128/calc/interp"
+	"github.com/rthornton128/calc/parse"
+	"github.com/rthornton128/calc/token"
+)
+
+func runMain(t *testing.T, path string) (int32, error) {
+	fset := token.NewFileSet()
+	f, err := parse.ParseFile(fset, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := &ast.CallExpr{Name: &ast.Ident{Name: "main"}}
+	return interp.New(fset).Eval(call, f.Scope)
+}
+
+func TestExamples(t *testing.T) {
+	var tests = []struct {
+		path string
+		exp  int32
+	}{
+		{"../examples/abs.calc", 24},
+		{"../examples/basic_math.calc", 12},
+		{"../examples/factorial.calc", 3628800},
+		{"../examples/ifexpr.calc", 2},
+		{"../examples/sicp1_3.calc", 34},
+		{"../examples/var.calc", 8},
+		{"../examples/zeroval.calc", 0},
+	}
+	for _, v := range tests {
+		res, err := runMain(t, v.path)
+		if err != nil {
+			t.Fatal(v.path, err)
+		}
+		if res != v.exp {
+			t.Fatal(v.path, "Expected:", v.exp, "Got:", res)
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	_, err := runMain(t, "../examples/overflow.calc")
+	if err == nil || !strings.Contains(err.Error(), "stack overflow") {
+		t.Fatal("Expected: stack overflow Got:", err)
+	}
+}
+
+func TestBitwise(t *testing.T) {
+	var tests = []struct {
+		src string
+		exp int32
+	}{
+		{"(& 12 10)", 8},
+		{"(| 12 1)", 13},
+		{"(^ 12 5)", 9},
+		{"(&^ 12 10)", 4},
+		{"(<< 1 4 2)", 64},
+		{"(<< 1 32)", 0},
+		{"(>> -16 2)", -4},
+		{"(>> -16 40)", -1},
+		{"^0", -1},
+		{"!0", 1},
+		{"not 7", 0},
+		{"+-3", -3},
+		{"0x7fffffff", 2147483647},
+	}
+	for _, v := range tests {
+		fset := token.NewFileSet()
+		scope := ast.NewScope(nil)
+		exprs, err := parse.ParseInput(fset, "", v.src, scope)
+		if err != nil {
+			t.Fatal(v.src, err)
+		}
+		res, err := interp.New(fset).Eval(exprs[0], scope)
+		if err != nil {
+			t.Fatal(v.src, err)
+		}
+		if res != v.exp {
+			t.Fatal(v.src, "Expected:", v.exp, "Got:", res)
+		}
+	}
+}
+
+func TestAssert(t *testing.T) {
+	var tests = []struct {
+		src string
+		err string
+	}{
+		{"(assert (== 1 1))", ""},
+		{"(assert 7 \"seven\")", ""},
+		{"(assert (== 1 2))", "1:1 assertion failed"},
+		{"(assert 0 \"a \\\"b\\\"\")", "1:1 assertion failed: a \"b\""},
+		{"(assert 1 2)", "1:11 assert message must be a string literal"},
+		{"(assert)", "1:2 assert takes a condition and an optional message, " +
+			"got 0 arguments"},
+	}
+	for _, v := range tests {
+		fset := token.NewFileSet()
+		scope := ast.NewScope(nil)
+		exprs, err := parse.ParseInput(fset, "", v.src, scope)
+		if err != nil {
+			t.Fatal(v.src, err)
+		}
+		_, err = interp.New(fset).Eval(exprs[0], scope)
+		if (err == nil && v.err != "") || (err != nil && err.Error() != v.err) {
+			t.Fatalf("%s - Expected: %q Got: %v", v.src, v.err, err)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+// Package load locates and parses Calc packages along with the packages
+// they import
+package load
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rthornton128/calc/ast"
+	"github.com/rthornton128/calc/parse"
+	"github.com/rthornton128/calc/token"
+)
+
+// Package is a parsed package and the packages it imports
+type Package struct {
+	*ast.Package
+	Dir     string     // directory containing the package sources
+	Imports []*Package // directly imported packages, in import order
+	Test    bool       // loaded by LoadTest, including its test files
+}
+
+// Loader loads packages, resolving import paths against the directories of
+// a search path. Each package is loaded once, however many packages import
+// it.
+type Loader struct {
+	fset    *token.FileSet
+	path    []string
+	pkgs    map[string]*Package // nil if the package failed to load
+	loading []string            // import paths currently being loaded, outermost first
+}
+
+// SearchPath returns the default search path for a program rooted in dir:
+// dir itself followed by the directories listed in the CALCPATH
+// environment variable.
+func SearchPath(dir string) []string {
+	list := []string{dir}
+	for _, p := range filepath.SplitList(os.Getenv("CALCPATH")) {
+		if p != "" {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// New returns a Loader that adds files to fset and searches the
+// directories in searchPath, in order, for imported packages
+func New(fset *token.FileSet, searchPath []string) *Loader {
+	return &Loader{
+		fset: fset,
+		path: searchPath,
+		pkgs: make(map[string]*Package),
+	}
+}
+
+// LoadDir loads the main package from the source files in dir, and any
+// packages it imports
+func (l *Loader) LoadDir(dir string) (*Package, error) {
+	pkg, err := parse.ParseDir(l.fset, dir)
+	if err != nil {
+		return nil, err
+	}
+	pkg.Name = "main"
+	return l.resolve(pkg, dir)
+}
+
+// LoadTest loads the package in dir, including its test files, as the
+// main package of a program running its tests. The package name is
+// unchanged. Imported packages never include their test files.
+func (l *Loader) LoadTest(dir string) (*Package, error) {
+	ap, err := parse.ParseTestDir(l.fset, dir)
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := l.resolve(ap, dir)
+	if err != nil {
+		return nil, err
+	}
+	pkg.Test = true
+	return pkg, nil
+}
+
+// LoadFile loads the main package from the single source file filename,
+// and any packages it imports
+func (l *Loader) LoadFile(filename string) (*Package, error) {
+	f, err := parse.ParseFile(l.fset, filename, nil)
+	if err != nil {
+		return nil, err
+	}
+	pkg := &ast.Package{Name: "main", Scope: f.Scope, Files: []*ast.File{f}}
+	return l.resolve(pkg, filepath.Dir(filename))
+}
+
+// LoadPackage loads the packages imported by ap, a package already parsed
+// from the source files in dir
+func (l *Loader) LoadPackage(ap *ast.Package, dir string) (*Package, error) {
+	return l.resolve(ap, dir)
+}
+
+// Import loads the package with the given import path
+func (l *Loader) Import(importPath string) (*Package, error) {
+	return l.load(importPath, token.Position{})
+}
+
+// Packages returns pkg and every package it imports, directly or
+// indirectly, ordered so that each package comes after the packages it
+// imports. Pkg is always last.
+func Packages(pkg *Package) []*Package {
+	var list []*Package
+	seen := make(map[*Package]bool)
+	var visit func(p *Package)
+	visit = func(p *Package) {
+		if seen[p] {
+			return
+		}
+		seen[p] = true
+		for _, imp := range p.Imports {
+			visit(imp)
+		}
+		list = append(list, p)
+	}
+	visit(pkg)
+	return list
+}
+
+func (l *Loader) load(importPath string, pos token.Position) (*Package, error) {
+	var errors token.ErrorList
+	if !validPath(importPath) {
+		errors.Add(pos, "invalid import path: ", importPath)
+		return nil, errors
+	}
+	for i, p := range l.loading {
+		if p == importPath {
+			cycle := append(l.loading[i:], importPath)
+			errors.Add(pos, "import cycle not allowed: ",
+				strings.Join(cycle, " imports "))
+			return nil, errors
+		}
+	}
+	if pkg, ok := l.pkgs[importPath]; ok {
+		if pkg == nil {
+			// the reason was reported when the package was first imported
+			errors.Add(pos, "could not import ", importPath)
+			return nil, errors
+		}
+		return pkg, nil
+	}
+
+	dir := l.find(importPath)
+	if dir == "" {
+		errors.Add(pos, "cannot find package \"", importPath, "\" in any of: ",
+			strings.Join(l.path, ", "))
+		return nil, errors
+	}
+
+	l.loading = append(l.loading, importPath)
+	defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+
+	var pkg *Package
+	ap, err := parse.ParseDir(l.fset, dir)
+	if err == nil {
+		ap.Name = path.Base(importPath)
+		ap.Path = importPath
+		pkg, err = l.resolve(ap, dir)
+	}
+	l.pkgs[importPath] = pkg
+	return pkg, err
+}
+
+// resolve loads the imports of every file in the package
+func (l *Loader) resolve(ap *ast.Package, dir string) (*Package, error) {
+	pkg := &Package{Package: ap, Dir: dir}
+	seen := make(map[*ast.Package]bool)
+
+	var errors token.ErrorList
+	for _, f := range ap.Files {
+		for _, imp := range f.Imports {
+			pos := l.fset.Position(imp.Path.Pos())
+			p, err := l.load(imp.ImportPath(), pos)
+			if list, ok := err.(token.ErrorList); ok {
+				errors = append(errors, list...)
+				continue
+			}
+			if err != nil {
+				errors.Add(pos, err)
+				continue
+			}
+			imp.Pkg = p.Package
+			if !seen[p.Package] {
+				seen[p.Package] = true
+				pkg.Imports = append(pkg.Imports, p)
+			}
+		}
+	}
+	if errors.Count() > 0 {
+		return nil, errors
+	}
+	return pkg, nil
+}
+
+// find returns the first directory in the search path containing the
+// package importPath or an empty string if there is none
+func (l *Loader) find(importPath string) string {
+	for _, root := range l.path {
+		dir := filepath.Join(root, filepath.FromSlash(importPath))
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			return dir
+		}
+	}
+	return ""
+}
+
+// validPath reports whether p is a clean, relative, slash separated path
+// that does not refer to a parent directory
+func validPath(p string) bool {
+	if p == "" || p == "." || path.IsAbs(p) || path.Clean(p) != p ||
+		strings.Contains(p, "\\") {
+		return false
+	}
+	return p != ".." && !strings.HasPrefix(p, "../")
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package load_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rthornton128/calc/load"
+	"github.com/rthornton128/calc/token"
+)
+
+// writeFiles creates the files, keyed by slash separated path, in a new
+// temporary directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.calc":      "(import \"a\")\n(import \"lib/b\")\n(decl main int 0)",
+		"a/a.calc":       "(import \"lib/b\")\n(decl A int 0)",
+		"lib/b/b.calc":   "(import \"c\")\n(decl B int 0)",
+		"path/c/c1.calc": "(decl C int 0)",
+		"path/c/c2.calc": "(import \"lib/b\")\n(decl D int 0)",
+	})
+	defer os.RemoveAll(dir)
+	path := []string{dir, filepath.Join(dir, "path")}
+
+	fset := token.NewFileSet()
+	pkg, err := load.New(fset, path).LoadDir(dir)
+	if err == nil {
+		t.Fatal("Expected: import cycle error")
+	}
+	exp := "c2.calc:1:9 import cycle not allowed: lib/b imports c imports lib/b"
+	if !strings.Contains(err.Error(), exp) {
+		t.Fatalf("Expected: %q Got: %q", exp, err)
+	}
+
+	os.Remove(filepath.Join(dir, "path", "c", "c2.calc"))
+	pkg, err = load.New(fset, path).LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, p := range load.Packages(pkg) {
+		names = append(names, p.Name+":"+p.Path)
+	}
+	if s := strings.Join(names, " "); s != "c:c b:lib/b a:a main:" {
+		t.Fatal("Expected: c:c b:lib/b a:a main: Got:", s)
+	}
+	a, b := pkg.Imports[0], pkg.Imports[1]
+	if a.Imports[0] != b {
+		t.Fatal("Expected: lib/b to be loaded once")
+	}
+	if ob := pkg.Files[0].Scope.Resolve("b.B"); ob == nil || ob.Name != "B" {
+		t.Fatal("Expected: b.B to resolve Got:", ob)
+	}
+}
+
+func TestLoadTest(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a/a.calc":      "(decl A int 0)",
+		"a/a_test.calc": "(import \"b\")\n(decl testA int (== A b.B))",
+		"b/b.calc":      "(decl B int 0)",
+		"b/b_test.calc": "(import \"missing\")",
+	})
+	defer os.RemoveAll(dir)
+
+	fset := token.NewFileSet()
+	l := load.New(fset, []string{dir})
+	pkg, err := l.LoadDir(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Test || len(pkg.Files) != 1 || len(pkg.Imports) != 0 {
+		t.Fatal("Expected: test files to be excluded by LoadDir")
+	}
+
+	pkg, err = l.LoadTest(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pkg.Test || len(pkg.Files) != 2 || pkg.Scope.Lookup("testA") == nil {
+		t.Fatal("Expected: test files to be included by LoadTest")
+	}
+	if len(pkg.Imports) != 1 || len(pkg.Imports[0].Files) != 1 {
+		t.Fatal("Expected: b to be imported without its test files")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.calc": "(import \"a\")\n(import up \"../a\")\n(import \"missing\")\n" +
+			"(decl main int 0)",
+		"a/a.calc":   "(import \"b\")\n(decl A int 0)",
+		"b/b.calc":   "(decl B int (+ 1))",
+		"c/c.calc":   "(import \"b\")\n(decl C int 0)",
+		"d/d.calc":   "(import \"c\")\n(import \"a\")\n(decl main int 0)",
+		"e/empty.go": "",
+	})
+	defer os.RemoveAll(dir)
+
+	fset := token.NewFileSet()
+	_, err := load.New(fset, []string{dir}).LoadFile(filepath.Join(dir,
+		"main.calc"))
+	if err == nil {
+		t.Fatal("Expected: errors")
+	}
+	for _, exp := range []string{
+		"b.calc:1:17 binary expression must have at least two operands",
+		"main.calc:2:12 invalid import path: ../a",
+		"main.calc:3:9 cannot find package \"missing\" in any of: " + dir,
+	} {
+		if !strings.Contains(err.Error(), exp) {
+			t.Fatalf("Expected: %q Got: %q", exp, err)
+		}
+	}
+
+	// a package that failed to load is only parsed once
+	_, err = load.New(fset, []string{dir}).LoadDir(filepath.Join(dir, "d"))
+	if err == nil || strings.Count(err.Error(), "binary expression") != 1 ||
+		!strings.Contains(err.Error(), "a.calc:1:9 could not import b") {
+		t.Fatal("Expected: b to fail to load once Got:", err)
+	}
+
+	_, err = load.New(fset, []string{dir}).Import("e")
+	if err == nil || !strings.Contains(err.Error(), "no files to parse") {
+		t.Fatal("Expected: no files to parse Got:", err)
+	}
+}
@@ -10,10 +10,16 @@ package parse
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/rthornton128/calc/ast"
 	"github.com/rthornton128/calc/scan"
@@ -29,7 +35,7 @@ func ParseExpression(name, src string) (ast.Node, error) {
 
 	fset := token.NewFileSet()
 	file := fset.Add(name, src)
-	p.init(file, name, string(src), nil)
+	p.init(file, name, string(src), nil, nil)
 	node := p.parseGenExpr()
 
 	if p.errors.Count() > 0 {
@@ -38,6 +44,26 @@ func ParseExpression(name, src string) (ast.Node, error) {
 	return node, nil
 }
 
+// ParseInput parses any number of expressions and declarations found in
+// src, such as the input to an interactive prompt. Objects are declared in
+// scope s, which becomes the top-level scope of the parse.
+func ParseInput(fset *token.FileSet, name, src string, s *ast.Scope) ([]ast.Expr, error) {
+	var p parser
+
+	file := fset.Add(name, src)
+	p.init(file, name, src, nil, s)
+
+	var list []ast.Expr
+	for p.tok != token.EOF {
+		list = append(list, p.parseGenExpr())
+	}
+
+	if p.errors.Count() > 0 {
+		return nil, p.errors
+	}
+	return list, nil
+}
+
 // ParseFile parses the file identified by filename and returns a pointer
 // to an ast.File object. The file should contain Calc source code and
 // have the .calc file extension.
@@ -48,8 +74,6 @@ func ParseFile(fset *token.FileSet, filename string, s *ast.Scope) (*ast.File, e
 		return nil, err
 	}
 
-	var p parser
-	var f *ast.File
 	if ext := filepath.Ext(fi.Name()); ext != ".calc" {
 		return nil, fmt.Errorf("unknown file extension, must be .calc")
 	}
@@ -57,9 +81,22 @@ func ParseFile(fset *token.FileSet, filename string, s *ast.Scope) (*ast.File, e
 	if err != nil {
 		return nil, err
 	}
-	file := fset.Add(filepath.Base(filename), string(src))
-	p.init(file, filename, string(src), s)
-	f = p.parseFile()
+	return ParseSource(fset, filename, string(src), s)
+}
+
+// ParseSource parses the Calc source code in src as though it were the
+// contents of the file identified by filename, which is only used for
+// position information. It is otherwise identical to ParseFile.
+func ParseSource(fset *token.FileSet, filename, src string, s *ast.Scope) (*ast.File, error) {
+	return parseSource(fset.Add(filepath.Base(filename), src), filename, src, s)
+}
+
+// parseSource parses src, the contents of file
+func parseSource(file *token.File, filename, src string, s *ast.Scope) (*ast.File,
+	error) {
+	var p parser
+	p.init(file, filename, src, nil, s)
+	f := p.parseFile()
 
 	if p.errors.Count() > 0 {
 		return nil, p.errors
@@ -68,9 +105,48 @@ func ParseFile(fset *token.FileSet, filename string, s *ast.Scope) (*ast.File, e
 	return f, nil
 }
 
-// ParseDir parses a directory of Calc source files. It calls ParseFile
-// for each file ending in .calc found in the directory.
+// ParseReader parses Calc source code read from r, such as standard input,
+// as though it were the contents of the file identified by filename. The
+// source is read incrementally as it is parsed. It is otherwise identical
+// to ParseFile.
+func ParseReader(fset *token.FileSet, filename string, r io.Reader, s *ast.Scope) (*ast.File, error) {
+	var p parser
+	file := fset.Add(filepath.Base(filename), "")
+	p.init(file, filename, "", r, s)
+	f := p.parseFile()
+
+	if p.errors.Count() > 0 {
+		return nil, p.errors
+	}
+
+	return f, nil
+}
+
+// ParseDir parses a directory of Calc source files. It parses each file
+// ending in .calc found in the directory, except for test files as reported
+// by IsTestFile. Files are added to fset in file name order and then parsed
+// concurrently, each into its own file scope whose parent is the package
+// scope. The top-level declarations of every file are then merged into the
+// package scope in file name order. Errors, such as a function declared in
+// more than one file, are sorted by file and then position.
 func ParseDir(fset *token.FileSet, path string) (*ast.Package, error) {
+	return parseDir(fset, path, false)
+}
+
+// ParseTestDir parses a directory of Calc source files like ParseDir but
+// includes the test files
+func ParseTestDir(fset *token.FileSet, path string) (*ast.Package, error) {
+	return parseDir(fset, path, true)
+}
+
+// IsTestFile reports whether the file name belongs to a test file, which
+// ends in _test.calc
+func IsTestFile(name string) bool {
+	return strings.HasSuffix(filepath.Base(name), "_test.calc")
+}
+
+func parseDir(fset *token.FileSet, path string, tests bool) (*ast.Package,
+	error) {
 	fd, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -81,29 +157,89 @@ func ParseDir(fset *token.FileSet, path string) (*ast.Package, error) {
 	if err != nil {
 		return nil, err
 	}
-	fnames = filterByExt(fnames)
+	fnames = filterByExt(fnames, tests)
 	if len(fnames) == 0 {
 		return nil, fmt.Errorf("no files to parse; stop")
 	}
+	sort.Strings(fnames)
 
-	var files []*ast.File
-	scope := ast.NewScope(nil)
-
-	// TODO: use concurrency
-	for _, name := range fnames {
-		f, err := ParseFile(fset, filepath.Join(path, name), scope)
-		if f == nil {
+	// files are added to fset in name order, before any is parsed, so that
+	// positions do not depend on the order in which the parsers finish
+	tfiles := make([]*token.File, len(fnames))
+	srcs := make([]string, len(fnames))
+	for i, name := range fnames {
+		src, err := ioutil.ReadFile(filepath.Join(path, name))
+		if err != nil {
 			return nil, err
 		}
-		files = append(files, f)
+		srcs[i] = string(src)
+		tfiles[i] = fset.Add(name, srcs[i])
+	}
+
+	files := make([]*ast.File, len(fnames))
+	errs := make([]error, len(fnames))
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	for i, name := range fnames {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, name string) {
+			defer func() { <-sem; wg.Done() }()
+			files[i], errs[i] = parseSource(tfiles[i], filepath.Join(path, name),
+				srcs[i], nil)
+		}(i, name)
+	}
+	wg.Wait()
+
+	var errors token.ErrorList
+	for _, err := range errs {
+		switch e := err.(type) {
+		case nil:
+		case token.ErrorList:
+			errors = append(errors, e...)
+		default:
+			return nil, err
+		}
+	}
+	if errors.Count() > 0 {
+		errors.Sort()
+		return nil, errors
+	}
+	return NewPackage(fset, path, files)
+}
+
+// NewPackage returns the package, named after the directory path, made up
+// of the parsed files. The top-level declarations of each file are merged
+// into the package scope in the order the files are given.
+func NewPackage(fset *token.FileSet, path string, files []*ast.File) (
+	*ast.Package, error) {
+	var errors token.ErrorList
+	scope := ast.NewScope(nil)
+	for _, f := range files {
+		for _, ob := range f.Scope.Objects() {
+			if ob.Kind == ast.Pkg {
+				continue // imports are local to the file importing them
+			}
+			if old := scope.Insert(ob); old != nil {
+				errors.Add(fset.Position(ob.NamePos), "redeclaration of '",
+					ob.Name, "' not allowed, originally declared at: ",
+					fset.Position(old.NamePos))
+			}
+		}
+		f.Scope.Parent = scope
+	}
+	if errors.Count() > 0 {
+		errors.Sort()
+		return nil, errors
 	}
-	return &ast.Package{Scope: scope, Files: files}, nil
+	return &ast.Package{Name: filepath.Base(path), Scope: scope,
+		Files: files}, nil
 }
 
-func filterByExt(names []string) []string {
+func filterByExt(names []string, tests bool) []string {
 	filtered := make([]string, 0, len(names))
 	for _, name := range names {
-		if filepath.Ext(name) == ".calc" {
+		if filepath.Ext(name) == ".calc" && (tests || !IsTestFile(name)) {
 			filtered = append(filtered, name)
 		}
 	}
@@ -119,6 +255,10 @@ type parser struct {
 	curScope *ast.Scope
 	topScope *ast.Scope
 
+	comments    []*ast.CommentGroup
+	leadComment *ast.CommentGroup
+	imports     []*ast.ImportExpr
+
 	pos token.Pos
 	tok token.Token
 	lit string
@@ -154,20 +294,72 @@ func (p *parser) expect(tok token.Token) token.Pos {
 	return pos
 }
 
-func (p *parser) init(file *token.File, fname, src string, s *ast.Scope) {
+func (p *parser) init(file *token.File, fname, src string, r io.Reader,
+	s *ast.Scope) {
 	if s == nil {
 		s = ast.NewScope(nil)
 	}
 	p.file = file
-	p.scanner.Init(p.file, src)
+	handler := func(pos token.Pos, msg string) {
+		p.errors.Add(p.file.Position(pos), msg)
+	}
+	if r != nil {
+		p.scanner.InitReader(p.file, r, handler, scan.ScanComments)
+	} else {
+		p.scanner.Init(p.file, src, handler, scan.ScanComments)
+	}
 	p.listok = false
+	p.imports = nil
 	p.curScope = s //ast.NewScope(nil)
 	p.topScope = p.curScope
 	p.next()
 }
 
+func (p *parser) line(pos token.Pos) int {
+	return p.file.Position(pos).Row
+}
+
+// next advances to the next non-comment token. Comments encountered along
+// the way are collected into groups. A group ending on the line immediately
+// before the next token becomes the lead (doc) comment for that token; a
+// comment on the same line as the previous token is kept separate so it is
+// never mistaken for documentation of what follows.
 func (p *parser) next() {
+	prev := p.pos
+	p.leadComment = nil
 	p.lit, p.tok, p.pos = p.scanner.Scan()
+
+	if p.tok == token.COMMENT {
+		var comment *ast.CommentGroup
+		endline := -1
+
+		if prev.Valid() && p.line(p.pos) == p.line(prev) {
+			p.consumeCommentGroup(0)
+		}
+
+		for p.tok == token.COMMENT {
+			comment, endline = p.consumeCommentGroup(1)
+		}
+
+		if endline+1 == p.line(p.pos) {
+			p.leadComment = comment
+		}
+	}
+}
+
+func (p *parser) consumeCommentGroup(n int) (*ast.CommentGroup, int) {
+	var list []*ast.Comment
+	endline := p.line(p.pos)
+	for p.tok == token.COMMENT && p.line(p.pos) <= endline+n {
+		c := &ast.Comment{Semicolon: p.pos, Text: p.lit}
+		list = append(list, c)
+		endline = p.line(c.End() - 1) // block comments may span lines
+		p.lit, p.tok, p.pos = p.scanner.Scan()
+	}
+
+	group := &ast.CommentGroup{List: list}
+	p.comments = append(p.comments, group)
+	return group, endline
 }
 
 /* Scope */
@@ -245,13 +437,13 @@ func (p *parser) parseCallExpr(open token.Pos) *ast.CallExpr {
 	}
 }
 
-func (p *parser) parseDeclExpr(open token.Pos) *ast.DeclExpr {
+func (p *parser) parseDeclExpr(open token.Pos, doc *ast.CommentGroup) *ast.DeclExpr {
 	if p.curScope != p.topScope {
 		p.addError("function declarations may only be used in top-level scope")
 		return nil
 	}
 	pos := p.expect(token.DECL)
-	nam := p.parseIdent()
+	nam := p.parseLocalIdent()
 
 	p.openScope()
 
@@ -271,6 +463,7 @@ func (p *parser) parseDeclExpr(open token.Pos) *ast.DeclExpr {
 			Opening: open,
 			Closing: end,
 		},
+		Doc:    doc,
 		Decl:   pos,
 		Name:   nam,
 		Type:   typ,
@@ -299,6 +492,7 @@ func (p *parser) parseDeclExpr(open token.Pos) *ast.DeclExpr {
 func (p *parser) parseExpr() ast.Expr {
 	var expr ast.Expr
 	listok := p.listok
+	doc := p.leadComment
 
 	pos := p.expect(token.LPAREN)
 	if p.listok && p.tok == token.LPAREN {
@@ -308,16 +502,20 @@ func (p *parser) parseExpr() ast.Expr {
 	p.listok = false
 	switch p.tok {
 	case token.ADD, token.SUB, token.MUL, token.QUO, token.REM,
-		token.EQL, token.GTE, token.GTT, token.NEQ, token.LST, token.LTE:
+		token.EQL, token.GTE, token.GTT, token.NEQ, token.LST, token.LTE,
+		token.AND, token.OR, token.BAND, token.BOR, token.XOR, token.ANDNOT,
+		token.SHL, token.SHR:
 		expr = p.parseBinaryExpr(pos)
 	case token.ASSIGN:
 		expr = p.parseAssignExpr(pos)
 	case token.DECL:
-		expr = p.parseDeclExpr(pos)
+		expr = p.parseDeclExpr(pos, doc)
 	case token.IDENT:
 		expr = p.parseCallExpr(pos)
 	case token.IF:
 		expr = p.parseIfExpr(pos)
+	case token.IMPORT:
+		expr = p.parseImportExpr(pos)
 	case token.VAR:
 		expr = p.parseVarExpr(pos)
 	default:
@@ -335,7 +533,7 @@ func (p *parser) parseExpr() ast.Expr {
 func (p *parser) parseExprList(open token.Pos) ast.Expr {
 	p.listok = false
 	var list []ast.Expr
-	for p.tok != token.RPAREN {
+	for p.tok != token.RPAREN && p.tok != token.EOF {
 		list = append(list, p.parseGenExpr())
 	}
 	if len(list) < 1 {
@@ -359,9 +557,9 @@ func (p *parser) parseGenExpr() ast.Expr {
 		expr = p.parseExpr()
 	case token.IDENT:
 		expr = p.parseIdent()
-	case token.INTEGER:
+	case token.INTEGER, token.STRING:
 		expr = p.parseBasicLit()
-	case token.SUB:
+	case token.ADD, token.SUB, token.NOT, token.XOR:
 		expr = p.parseUnaryExpr()
 	default:
 		p.addError("Expected expression, got '" + p.lit + "'")
@@ -379,7 +577,13 @@ func (p *parser) parseFile() *ast.File {
 	if p.topScope.Size() < 1 {
 		p.addError("reached end of file without any declarations")
 	}
-	return &ast.File{Scope: p.topScope}
+	return &ast.File{
+		FileStart: token.Pos(p.file.Base()),
+		FileEnd:   p.file.End(),
+		Scope:     p.topScope,
+		Imports:   p.imports,
+		Comments:  p.comments,
+	}
 }
 
 func (p *parser) parseIdent() *ast.Ident {
@@ -388,6 +592,17 @@ func (p *parser) parseIdent() *ast.Ident {
 	return &ast.Ident{NamePos: pos, Name: name}
 }
 
+// parseLocalIdent parses the name of an object being declared, which may
+// not be qualified by a package name
+func (p *parser) parseLocalIdent() *ast.Ident {
+	ident := p.parseIdent()
+	if pkg, _ := ast.SplitQualified(ident.Name); pkg != "" {
+		p.errors.Add(p.file.Position(ident.NamePos), "can not declare "+
+			"qualified name '", ident.Name, "'")
+	}
+	return ident
+}
+
 func (p *parser) parseIfExpr(open token.Pos) *ast.IfExpr {
 	pos := p.expect(token.IF)
 	cond := p.parseGenExpr()
@@ -421,24 +636,75 @@ func (p *parser) parseIfExpr(open token.Pos) *ast.IfExpr {
 	}
 }
 
+func (p *parser) parseImportExpr(open token.Pos) *ast.ImportExpr {
+	if p.curScope != p.topScope {
+		p.addError("imports may only be used in top-level scope")
+		return nil
+	}
+	pos := p.expect(token.IMPORT)
+
+	var name *ast.Ident
+	if p.tok == token.IDENT {
+		name = p.parseIdent()
+	}
+
+	var path *ast.BasicLit
+	if p.tok == token.STRING {
+		path = p.parseBasicLit()
+		if s, err := strconv.Unquote(path.Lit); err != nil || s == "" {
+			p.errors.Add(p.file.Position(path.LitPos), "invalid import path: ",
+				path.Lit)
+		}
+	} else {
+		path = &ast.BasicLit{LitPos: p.pos, Kind: token.STRING, Lit: `""`}
+		p.addError("Expected import path but got '" + p.lit + "'")
+	}
+	end := p.expect(token.RPAREN)
+
+	imp := &ast.ImportExpr{
+		Expression: ast.Expression{Opening: open, Closing: end},
+		Import:     pos,
+		Name:       name,
+		Path:       path,
+	}
+	npos := path.LitPos
+	if name != nil {
+		npos = name.NamePos
+	}
+	ob := &ast.Object{
+		NamePos: npos,
+		Name:    imp.LocalName(),
+		Kind:    ast.Pkg,
+		Value:   imp,
+	}
+	if old := p.curScope.Insert(ob); old != nil {
+		p.errors.Add(p.file.Position(npos), "redeclaration of '", ob.Name,
+			"' not allowed, originally declared at: ",
+			p.file.Position(old.NamePos))
+	}
+	p.imports = append(p.imports, imp)
+
+	return imp
+}
+
 func (p *parser) parseParamList() []*ast.Ident {
 	var list []*ast.Ident
-	count, start := 0, 0
-	for p.tok != token.RPAREN {
+	start := 0
+	for p.tok != token.RPAREN && p.tok != token.EOF {
 		ident := p.parseIdent()
-		count++
 		if p.tok == token.COMMA || p.tok == token.RPAREN {
 			for _, param := range list[start:] {
 				if param.Object == nil {
 					param.Object = &ast.Object{
-						Kind: ast.Var,
-						Name: param.Name,
+						NamePos: param.NamePos,
+						Kind:    ast.Var,
+						Name:    param.Name,
 					}
 				}
 				param.Object.Type = ident
 				p.curScope.Insert(param.Object)
 			}
-			start = count
+			start = len(list)
 			continue
 		}
 		list = append(list, ident)
@@ -451,7 +717,7 @@ func (p *parser) parseParamList() []*ast.Ident {
 }
 
 func (p *parser) parseUnaryExpr() *ast.UnaryExpr {
-	pos, op := p.pos, p.lit
+	pos, op := p.pos, p.tok
 	p.next()
 	exp := p.parseGenExpr()
 	return &ast.UnaryExpr{OpPos: pos, Op: op, Value: p.checkExpr(exp)}
@@ -467,12 +733,12 @@ func (p *parser) parseVarExpr(open token.Pos) *ast.VarExpr {
 
 	switch p.tok {
 	case token.IDENT:
-		name = p.parseIdent()
+		name = p.parseLocalIdent()
 	case token.LPAREN:
 		value = p.parseAssignExpr(p.expect(token.LPAREN))
 		name = value.Name
 	default:
-		name = &ast.Ident{token.NoPos, "NoName", nil}
+		name = &ast.Ident{NamePos: p.pos, Name: "NoName"}
 		p.addError("expected identifier or assignment")
 	}
 	if value == nil || p.tok == token.IDENT {
@@ -8,10 +8,17 @@
 package parse_test
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/rthornton128/calc/ast"
 	"github.com/rthornton128/calc/parse"
+	"github.com/rthornton128/calc/token"
 )
 
 type Test struct {
@@ -116,16 +123,19 @@ func TestParseBinary(t *testing.T) {
 		{"basic1", "(+ 2 3)", []Type{BINARY, BASIC, BASIC}, true},
 		{"basic2", "(+ 2 b)", []Type{BINARY, BASIC, IDENT}, true},
 		{"basic3", "(+ a b)", []Type{BINARY, IDENT, IDENT}, true},
-		{"basic4", "+ 3 5)", []Type{}, false},
+		{"basic4", "* 3 5)", []Type{}, false},
 		{"basic5", "(- 5)", []Type{}, false},
 		{"basic6", "(3 5 +)", []Type{}, false},
 		{"basic7", "(3 + 4)", []Type{}, false},
 		{"basic8", "(+ 6 2", []Type{}, false},
 		{"basic9", "(d", []Type{}, false},
 		{"basic10", "(% / d)", []Type{}, false},
-		{"basic11", "(& 3 5)", []Type{}, false},
+		{"basic11", "(& 3 5)", []Type{BINARY, BASIC, BASIC}, true},
 		{"basic12", "((+ 3 5) 5)", []Type{}, false},
 		{"basic13", "(* (- 2 6) (+ 4 2)())", []Type{}, false},
+		{"basic14", "(~ 3 5)", []Type{}, false},
+		{"basic15", "(<< a (&^ 3 b))", []Type{BINARY, IDENT, BINARY, BASIC,
+			IDENT}, true},
 	}
 	handleTests(t, tests)
 }
@@ -191,8 +201,21 @@ func TestParseUnary(t *testing.T) {
 		{"unary3", "-(foo)", []Type{UNARY, CALL, IDENT}, true},
 		{"unary4", "-(+ 2 3)", []Type{UNARY, BINARY, BASIC, BASIC}, true},
 		{"unary5", "-(decl foo int)", []Type{}, false},
+		{"unary6", "+3", []Type{UNARY, BASIC}, true},
+		{"unary7", "!(== a 1)", []Type{UNARY, BINARY, IDENT, BASIC}, true},
+		{"unary8", "not a", []Type{UNARY, IDENT}, true},
+		{"unary9", "^-a", []Type{UNARY, UNARY, IDENT}, true},
+		{"unary10", "!)", []Type{}, false},
 	}
 	handleTests(t, tests)
+
+	n, err := parse.ParseExpression("not", "not a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := n.(*ast.UnaryExpr); u.Op != token.NOT {
+		t.Fatal("Expected:", token.NOT, "Got:", u.Op)
+	}
 }
 
 func TestParseVar(t *testing.T) {
@@ -207,3 +230,318 @@ func TestParseVar(t *testing.T) {
 	}
 	handleTests(t, tests)
 }
+
+func TestParseDocComment(t *testing.T) {
+	tests := []struct {
+		src string
+		doc string
+	}{
+		{"; add returns the sum\n; of a and b\n(decl add(a b int) int (+ a b))",
+			"add returns the sum\nof a and b\n"},
+		{"; detached\n\n(decl one int 1)", ""},
+		{"(decl one int 1)", ""},
+		{"#| one returns\n   the number one |#\n(decl one int 1)",
+			"one returns\n  the number one\n"},
+		{"; one\n#;(decl two int 2)\n(decl one int 1)", "one\n"},
+	}
+	for i, test := range tests {
+		n, err := parse.ParseExpression("doc", test.src)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		decl, ok := n.(*ast.DeclExpr)
+		if !ok {
+			t.Fatal(i, "Expected: *ast.DeclExpr Got:", n)
+		}
+		if doc := decl.Doc.Text(); doc != test.doc {
+			t.Fatalf("%d - Expected: %q Got: %q", i, test.doc, doc)
+		}
+	}
+}
+
+func TestParseDatumComment(t *testing.T) {
+	src := "#;(decl old int 0)\n(decl main int (+ 1 #;(old) 2))"
+	f, err := parse.ParseSource(token.NewFileSet(), "datum.calc", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Scope.Lookup("old") != nil || f.Scope.Lookup("main") == nil {
+		t.Fatal("Expected: only main to be declared")
+	}
+	ob := f.Scope.Lookup("main")
+	if b := ob.Value.(*ast.DeclExpr).Body.(*ast.BinaryExpr); len(b.List) != 2 {
+		t.Fatal("Expected: 2 operands Got:", len(b.List))
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	src := "; add two numbers\n(decl add (a b int) int (+ a b))\n" +
+		"(decl main int (add 1 2))"
+	fset := token.NewFileSet()
+	f, err := parse.ParseReader(fset, "stdin.calc",
+		iotest.HalfReader(strings.NewReader(src)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := fset.Text(ast.RangeOf(f)); text != src {
+		t.Fatalf("Expected: %q Got: %q", src, text)
+	}
+	ob := f.Scope.Lookup("main")
+	if ob == nil || fset.Position(ob.NamePos).String() != "stdin.calc:3:7" {
+		t.Fatal("Expected: main at stdin.calc:3:7 Got:", ob)
+	}
+
+	_, err = parse.ParseReader(token.NewFileSet(), "stdin.calc",
+		strings.NewReader("(decl main int\n  (+ 1 $))"), nil)
+	if err == nil || !strings.Contains(err.Error(), "2:8 illegal character") {
+		t.Fatal("Expected: illegal character error Got:", err)
+	}
+}
+
+func TestNodeRanges(t *testing.T) {
+	src := "; comment\n(decl add (a b int) int\n\t(+ a -b (sub 1 2)))"
+	fset := token.NewFileSet()
+	f, err := parse.ParseSource(fset, "range.calc", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := fset.Text(ast.RangeOf(f)); text != src {
+		t.Fatalf("File - Expected: %q Got: %q", src, text)
+	}
+
+	expected := []string{
+		"(decl add (a b int) int\n\t(+ a -b (sub 1 2)))",
+		"; comment", "; comment", "add", "a", "b", "int",
+		"(+ a -b (sub 1 2))", "a", "-b", "b", "(sub 1 2)", "sub", "1", "2",
+	}
+	var i int
+	ast.Inspect(f.Scope.Lookup("add").Value, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		if text := fset.Text(ast.RangeOf(n)); text != expected[i] {
+			t.Fatalf("%d - Expected: %q Got: %q", i, expected[i], text)
+		}
+		i++
+		return true
+	})
+}
+
+func TestParseDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const n = 50
+	for i := 0; i < n; i++ {
+		src := fmt.Sprintf("(decl f%02d int (f%02d))", i, (i+1)%n)
+		name := filepath.Join(dir, fmt.Sprintf("f%02d.calc", i))
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fset := token.NewFileSet()
+	pkg, err := parse.ParseDir(fset, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkg.Files) != n || pkg.Scope.Size() != n {
+		t.Fatal("Expected:", n, "Got:", len(pkg.Files), pkg.Scope.Size())
+	}
+	for i, f := range pkg.Files {
+		if i > 0 && f.Pos() < pkg.Files[i-1].Pos() {
+			t.Fatal("Expected: files added to the FileSet in name order")
+		}
+		name := fmt.Sprintf("f%02d", i)
+		ob := f.Scope.Lookup(name)
+		if ob == nil || pkg.Scope.Objects()[i] != ob || f.Scope.Parent != pkg.Scope {
+			t.Fatal("Expected:", name, "in file and package scope")
+		}
+		if pos := fset.Position(ob.NamePos); pos.Filename != name+".calc" {
+			t.Fatal("Expected:", name+".calc", "Got:", pos.Filename)
+		}
+		// calls to declarations in other files resolve via the package scope
+		body := ob.Value.(*ast.DeclExpr)
+		if body.Scope.Lookup(fmt.Sprintf("f%02d", (i+1)%n)) == nil {
+			t.Fatal("Expected: call in", name, "to resolve")
+		}
+	}
+
+	for _, name := range []string{"f10", "f03"} {
+		src := "\n(decl " + name + " int 0)"
+		if err := ioutil.WriteFile(filepath.Join(dir, "z"+name+".calc"),
+			[]byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		_, err = parse.ParseDir(token.NewFileSet(), dir)
+		exp := "zf03.calc:2:7 redeclaration of 'f03' not allowed, originally " +
+			"declared at: f03.calc:1:7\nzf10.calc:2:7 redeclaration of 'f10' not " +
+			"allowed, originally declared at: f10.calc:1:7\n"
+		if err == nil || err.Error() != exp {
+			t.Fatalf("Expected: %q Got: %q", exp, err)
+		}
+	}
+
+	for _, name := range []string{"f10", "f03"} {
+		src := "(decl g int )\n(decl h int )"
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".calc"),
+			[]byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var first string
+	for i := 0; i < 5; i++ {
+		_, err = parse.ParseDir(token.NewFileSet(), dir)
+		list, ok := err.(token.ErrorList)
+		if !ok || list[0].Pos().Filename != "f03.calc" ||
+			list[len(list)-1].Pos().Filename != "f10.calc" {
+			t.Fatal("Expected: errors in f03.calc and f10.calc Got:", err)
+		}
+		for j := 1; j < len(list); j++ {
+			a, b := list[j-1].Pos(), list[j].Pos()
+			if a.Filename == b.Filename && (a.Row > b.Row ||
+				a.Row == b.Row && a.Col > b.Col) {
+				t.Fatal("Expected: errors sorted by position Got:", err)
+			}
+		}
+		if i == 0 {
+			first = err.Error()
+		} else if err.Error() != first {
+			t.Fatalf("Expected: %q Got: %q", first, err)
+		}
+	}
+}
+
+func TestParseTestDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"add.calc":      "(decl add (a b int) int (+ a b))",
+		"add_test.calc": "(decl testAdd int (assert (== (add 1 2) 3) \"1+2\"))",
+	}
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src),
+			0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pkg, err := parse.ParseDir(token.NewFileSet(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkg.Files) != 1 || pkg.Scope.Lookup("testAdd") != nil {
+		t.Fatal("Expected: test file to be excluded")
+	}
+
+	pkg, err = parse.ParseTestDir(token.NewFileSet(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ob := pkg.Scope.Lookup("testAdd")
+	if len(pkg.Files) != 2 || ob == nil {
+		t.Fatal("Expected: test file to be included")
+	}
+	call := ob.Value.(*ast.DeclExpr).Body.(*ast.CallExpr)
+	if lit, ok := call.Args[1].(*ast.BasicLit); !ok || lit.Kind != token.STRING {
+		t.Fatal("Expected: string literal argument Got:", call.Args[1])
+	}
+}
+
+func TestParseImport(t *testing.T) {
+	src := "(import \"lib/math\")\n(import m \"lib/math2\")\n" +
+		"(decl main int (+ (math.Square 2) m.Zero))"
+	f, err := parse.ParseSource(token.NewFileSet(), "import.calc", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Imports) != 2 {
+		t.Fatal("Expected: 2 imports Got:", len(f.Imports))
+	}
+	for i, name := range []string{"math", "m"} {
+		ob := f.Scope.Lookup(name)
+		if ob == nil || ob.Kind != ast.Pkg || ob.Value != f.Imports[i] {
+			t.Fatal("Expected: import", name, "in file scope")
+		}
+	}
+	if path := f.Imports[1].ImportPath(); path != "lib/math2" {
+		t.Fatal("Expected: lib/math2 Got:", path)
+	}
+
+	tests := []struct {
+		src, err string
+	}{
+		{"(import \"a\")\n(import \"b/a\")\n(decl main int 0)",
+			"imp.calc:2:9 redeclaration of 'a' not allowed"},
+		{"(decl main int ((import \"a\") 0))",
+			"imports may only be used in top-level scope"},
+		{"(import a)\n(decl main int 0)", "Expected import path but got ')'"},
+		{"(import \"\")\n(decl main int 0)", "invalid import path"},
+		{"(decl a.b int 0)", "can not declare qualified name 'a.b'"},
+	}
+	for i, test := range tests {
+		_, err := parse.ParseSource(token.NewFileSet(), "imp.calc", test.src, nil)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatal(i, "- Expected:", test.err, "Got:", err)
+		}
+	}
+}
+
+// FuzzParse checks that parsing any input, as a file, as the input to the
+// interactive prompt or as a single expression, reports errors rather than
+// panicking and that any file parsed without errors can be printed. The
+// seed corpus is the examples.
+func FuzzParse(f *testing.F) {
+	addExamples(f)
+	f.Add("((decl - (main")           // once looped forever at the end of the input
+	f.Add("(decl f (a b%ix,) int 0)") // once panicked
+	f.Fuzz(func(t *testing.T, src string) {
+		fset := token.NewFileSet()
+		file, err := parse.ParseSource(fset, "fuzz.calc", src, nil)
+		if _, ok := err.(token.ErrorList); err != nil && !ok {
+			t.Fatal("Expected: token.ErrorList Got:", err)
+		}
+		if err == nil {
+			for _, format := range []ast.Format{ast.TreeFormat, ast.JSONFormat,
+				ast.SExprFormat} {
+				if err := ast.Fprint(ioutil.Discard, fset, file, format); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		_, err = parse.ParseInput(fset, "input", src, ast.NewScope(nil))
+		if _, ok := err.(token.ErrorList); err != nil && !ok {
+			t.Fatal("Expected: token.ErrorList Got:", err)
+		}
+		parse.ParseExpression("expr", src)
+	})
+}
+
+// addExamples adds the source of every file in the examples directory to
+// the seed corpus of f
+func addExamples(f *testing.F) {
+	err := filepath.Walk(filepath.Join("..", "examples"),
+		func(path string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() || filepath.Ext(path) != ".calc" {
+				return err
+			}
+			src, err := ioutil.ReadFile(path)
+			if err == nil {
+				f.Add(string(src))
+			}
+			return err
+		})
+	if err != nil {
+		f.Fatal(err)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+// Package repl implements an interactive read-eval-print loop for Calc
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rthornton128/calc/ast"
+	"github.com/rthornton128/calc/comp"
+	"github.com/rthornton128/calc/interp"
+	"github.com/rthornton128/calc/parse"
+	"github.com/rthornton128/calc/scan"
+	"github.com/rthornton128/calc/token"
+)
+
+const (
+	prompt     = "> "
+	contPrompt = "... "
+)
+
+const help = `Enter expressions or declarations to evaluate them. Input continues
+over multiple lines until all parentheses are balanced.
+
+Commands:
+  :type expr    print the type of expr without evaluating it
+  :ast expr     print the syntax tree of expr
+  :load file    load the declarations in a .calc file
+  :help         print this message
+  :quit         exit the REPL
+`
+
+// REPL holds the state of an interactive session. Declarations and
+// variables persist from one input to the next.
+type REPL struct {
+	fset   *token.FileSet
+	scope  *ast.Scope
+	interp *interp.Interp
+	out    io.Writer
+	quit   bool
+}
+
+// New creates a new REPL writing its output to w
+func New(w io.Writer) *REPL {
+	fset := token.NewFileSet()
+	return &REPL{
+		fset:   fset,
+		scope:  ast.NewScope(nil),
+		interp: interp.New(fset),
+		out:    w,
+	}
+}
+
+// Run reads input from r until the end of input or a :quit command
+func (r *REPL) Run(in io.Reader) error {
+	s := bufio.NewScanner(in)
+	var buf string
+
+	fmt.Fprint(r.out, prompt)
+	for !r.quit && s.Scan() {
+		line := s.Text()
+		if buf == "" && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			r.Command(strings.TrimSpace(line))
+		} else {
+			buf += line + "\n"
+			if balance(buf) > 0 {
+				fmt.Fprint(r.out, contPrompt)
+				continue
+			}
+			r.Eval(buf)
+			buf = ""
+		}
+		if !r.quit {
+			fmt.Fprint(r.out, prompt)
+		}
+	}
+	if buf != "" {
+		r.Eval(buf)
+	}
+	return s.Err()
+}
+
+// balance returns the number of unclosed parentheses in src, ignoring any
+// found in comments and string literals. A comment left open at the end of
+// src, which continues on the next line, counts as one.
+func balance(src string) int {
+	var s scan.Scanner
+	s.Init(token.NewFile("input", 1, len(src)), src, nil, scan.ScanComments)
+	n := 0
+	for {
+		errs := s.ErrorCount
+		lit, tok, pos := s.Scan()
+		switch tok {
+		case token.EOF:
+			return n
+		case token.COMMENT:
+			// only an unterminated comment extends to the end with an error
+			if s.ErrorCount > errs && int(pos)-1+len(lit) == len(src) {
+				return n + 1
+			}
+		case token.LPAREN:
+			n++
+		case token.RPAREN:
+			n--
+		}
+	}
+}
+
+func (r *REPL) error(err error) {
+	if list, ok := err.(token.ErrorList); ok {
+		fmt.Fprint(r.out, list)
+		return
+	}
+	fmt.Fprintln(r.out, err)
+}
+
+// parse parses src in a new scope nested inside the current one so that
+// nothing is declared if there is an error
+func (r *REPL) parse(src string) ([]ast.Expr, *ast.Scope, bool) {
+	if strings.TrimSpace(src) == "" {
+		return nil, nil, false
+	}
+	scope := ast.NewScope(r.scope)
+	list, err := parse.ParseInput(r.fset, "input", src, scope)
+	if err != nil {
+		r.error(err)
+		return nil, nil, false
+	}
+	return list, scope, true
+}
+
+// Eval parses and evaluates src, printing the value and type of each
+// expression
+func (r *REPL) Eval(src string) {
+	list, scope, ok := r.parse(src)
+	if !ok {
+		return
+	}
+	r.scope = scope
+
+	for _, e := range list {
+		v, err := r.interp.Eval(e, scope)
+		if err != nil {
+			r.error(err)
+			return
+		}
+		if _, ok := e.(*ast.DeclExpr); !ok {
+			fmt.Fprintln(r.out, v, ":", comp.TypeOf(e, scope).Name)
+		}
+	}
+}
+
+// Command executes a REPL command such as :type
+func (r *REPL) Command(line string) {
+	cmd, arg := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		cmd, arg = line[:i], strings.TrimSpace(line[i:])
+	}
+
+	switch cmd {
+	case ":type":
+		if list, scope, ok := r.parse(arg); ok {
+			for _, e := range list {
+				fmt.Fprintln(r.out, comp.TypeOf(e, scope).Name)
+			}
+		}
+	case ":ast":
+		if list, _, ok := r.parse(arg); ok {
+			for _, e := range list {
+				ast.Fprint(r.out, r.fset, e, ast.TreeFormat)
+			}
+		}
+	case ":load":
+		scope := ast.NewScope(r.scope)
+		if _, err := parse.ParseFile(r.fset, arg, scope); err != nil {
+			r.error(err)
+			return
+		}
+		r.scope = scope
+	case ":help":
+		fmt.Fprint(r.out, help)
+	case ":quit":
+		r.quit = true
+	default:
+		fmt.Fprintln(r.out, "unknown command", cmd, "(try :help)")
+	}
+}
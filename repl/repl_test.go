@@ -0,0 +1,70 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package repl_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rthornton128/calc/repl"
+)
+
+func test_handler(t *testing.T, input, expected string) {
+	var buf bytes.Buffer
+	if err := repl.New(&buf).Run(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	out := strings.Replace(buf.String(), "... ", "", -1)
+	out = strings.Replace(out, "> ", "", -1)
+	if out != expected {
+		t.Fatalf("For %q expected %q got %q", input, expected, out)
+	}
+}
+
+func TestEval(t *testing.T) {
+	test_handler(t, "(+ 5 3)\n-(* 2 3)\n", "8 : int\n-6 : int\n")
+}
+
+func TestPersistentScope(t *testing.T) {
+	test_handler(t, "(decl square (n int) int (* n n))\n(var (= a 4))\n"+
+		"(square a)\n(= a 5)\n(square a)\n:type a\n",
+		"4 : int\n16 : int\n5 : int\n25 : int\nint\n")
+}
+
+func TestMultiLine(t *testing.T) {
+	test_handler(t, "(decl fact (n int) int ; comment (\n"+
+		"(if (<= n 1) int 1\n(* n (fact (- n 1)))))\n(fact 10)\n",
+		"3628800 : int\n")
+}
+
+func TestComments(t *testing.T) {
+	// input ending on a balanced line is evaluated before :quit is read
+	test_handler(t, "#| ( |# (+ 1 2)\n:quit\n", "3 : int\n")
+	test_handler(t, "#;(assert 0 \")\") (+ 1 2)\n:quit\n", "3 : int\n")
+	test_handler(t, "#; (\n(+ 1 2)) (- 1 2)\n:quit\n", "-1 : int\n")
+	test_handler(t, "#| (\n|# (+ 1 2)\n:quit\n", "3 : int\n")
+}
+
+func TestStrings(t *testing.T) {
+	test_handler(t, "(assert 1 \"(\")\n:quit\n", "1 : int\n")
+	test_handler(t, "(assert (< 1\n2) \")\")\n:quit\n", "1 : int\n")
+}
+
+func TestLoad(t *testing.T) {
+	test_handler(t, ":load ../examples/sicp1_3.calc\n(largestTwoOfThree 5 2 3)\n"+
+		":quit\n(main)\n", "34 : int\n")
+}
+
+func TestErrors(t *testing.T) {
+	test_handler(t, "(+ 1)\n(foo)\n(/ 1 0)\n:bogus\n",
+		"input:1:5 binary expression must have at least two operands\n"+
+			"input:1:2 undeclared identifier 'foo'\n"+
+			"input:1:6 integer divide by zero\n"+
+			"unknown command :bogus (try :help)\n")
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2014, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+// Package calc holds the sources of the C runtime library which every
+// compiled Calc program is linked against. The runtime can not be embedded
+// by a package in the runtime directory itself since Go does not allow C
+// source files in a package that does not use cgo.
+package calc
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed runtime/*.h runtime/cmp.c runtime/instructions.c
+//go:embed runtime/registers.c runtime/stack.c runtime/testing.c
+var runtimeFiles embed.FS
+
+// Runtime contains the runtime headers and C source files, excluding the
+// runtime's own tests
+var Runtime fs.FS
+
+func init() {
+	var err error
+	if Runtime, err = fs.Sub(runtimeFiles, "runtime"); err != nil {
+		panic(err)
+	}
+}
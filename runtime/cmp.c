@@ -28,3 +28,5 @@ void andl(char *a, char *b) {
 void orl(char *a, char *b) {
        setl(*(int32_t *)a >= 1 || *(int32_t *)b >= 1, eax);
 }
+
+void lnotl(char *a) { setl(*(int32_t *)a == 0, eax); }
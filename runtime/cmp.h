@@ -15,4 +15,8 @@ void ltl(char *a, char *b);
 void eql(char *a, char *b);
 void nel(char *a, char *b);
 
+void andl(char *a, char *b);
+void orl(char *a, char *b);
+void lnotl(char *a);
+
 #endif
@@ -57,5 +57,24 @@ void setl(const int32_t n, char *dest) { movl((char *)&n, dest); }
 void addl(const char *src, char *dest) { *(int32_t *)dest += *(int32_t *)src; }
 void divl(const char *src, char *dest) { *(int32_t *)dest /= *(int32_t *)src; }
 void mull(const char *src, char *dest) { *(int32_t *)dest *= *(int32_t *)src; }
+void negl(char *dest) { *(uint32_t *)dest = -*(uint32_t *)dest; }
 void reml(const char *src, char *dest) { *(int32_t *)dest %= *(int32_t *)src; }
 void subl(const char *src, char *dest) { *(int32_t *)dest -= *(int32_t *)src; }
+
+/* bitwise, shift counts are unsigned and saturate at the register width */
+void andnl(const char *src, char *dest) { *(int32_t *)dest &= ~*(int32_t *)src; }
+void bandl(const char *src, char *dest) { *(int32_t *)dest &= *(int32_t *)src; }
+void borl(const char *src, char *dest) { *(int32_t *)dest |= *(int32_t *)src; }
+void notl(char *dest) { *(int32_t *)dest = ~*(int32_t *)dest; }
+void xorl(const char *src, char *dest) { *(int32_t *)dest ^= *(int32_t *)src; }
+
+void sarl(const char *src, char *dest) {
+	uint32_t n = *(uint32_t *)src;
+	int32_t d = *(int32_t *)dest;
+	*(int32_t *)dest = n >= 32 ? (d < 0 ? -1 : 0) : d >> n;
+}
+
+void shll(const char *src, char *dest) {
+	uint32_t n = *(uint32_t *)src;
+	*(uint32_t *)dest = n >= 32 ? 0 : *(uint32_t *)dest << n;
+}
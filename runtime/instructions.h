@@ -21,7 +21,16 @@ void setl(const int32_t n, char *dest);
 void addl(const char *src, char *dest);
 void divl(const char *src, char *dest);
 void mull(const char *src, char *dest);
+void negl(char *dest);
 void reml(const char *src, char *dest);
 void subl(const char *src, char *dest);
 
+void andnl(const char *src, char *dest);
+void bandl(const char *src, char *dest);
+void borl(const char *src, char *dest);
+void notl(char *dest);
+void sarl(const char *src, char *dest);
+void shll(const char *src, char *dest);
+void xorl(const char *src, char *dest);
+
 #endif
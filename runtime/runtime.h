@@ -12,5 +12,6 @@
 #include "instructions.h"
 #include "registers.h"
 #include "stack.h"
+#include "testing.h"
 
 #endif
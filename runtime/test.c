@@ -44,6 +44,10 @@ void cmp_tests() {
 	orl((char *)&a, (char *)&a); assert(*(int32_t *)eax == 0);
 	orl((char *)&a, (char *)&b); assert(*(int32_t *)eax == 1);
 	orl((char *)&b, (char *)&b); assert(*(int32_t *)eax == 1);
+
+	/* not tests */
+	lnotl((char *)&a); assert(*(int32_t *)eax == 1);
+	lnotl((char *)&b); assert(*(int32_t *)eax == 0);
 }
 
 void instructions_tests() {
@@ -73,11 +77,50 @@ void instructions_tests() {
 	mull(edx, eax);
 	assert(*(int32_t *)eax == 15);
 
+	/* negation */
+	setl(7, eax);
+	negl(eax);
+	assert(*(int32_t *)eax == -7);
+
 	/* subtraction */
 	setl(3, eax);
 	setl(5, edx);
 	subl(edx, eax);
 	assert(*(int32_t *)eax == -2);
+
+	/* bitwise */
+	setl(0xc, eax);
+	setl(0xa, edx);
+	bandl(edx, eax);
+	assert(*(int32_t *)eax == 0x8);
+	setl(0xc, eax);
+	borl(edx, eax);
+	assert(*(int32_t *)eax == 0xe);
+	setl(0xc, eax);
+	xorl(edx, eax);
+	assert(*(int32_t *)eax == 0x6);
+	setl(0xc, eax);
+	andnl(edx, eax);
+	assert(*(int32_t *)eax == 0x4);
+	setl(0, eax);
+	notl(eax);
+	assert(*(int32_t *)eax == -1);
+
+	/* shifts */
+	setl(1, eax);
+	setl(4, edx);
+	shll(edx, eax);
+	assert(*(int32_t *)eax == 16);
+	setl(32, edx);
+	shll(edx, eax);
+	assert(*(int32_t *)eax == 0);
+	setl(-16, eax);
+	setl(2, edx);
+	sarl(edx, eax);
+	assert(*(int32_t *)eax == -4);
+	setl(40, edx);
+	sarl(edx, eax);
+	assert(*(int32_t *)eax == -1);
 }
 
 void stack_tests() {
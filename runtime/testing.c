@@ -0,0 +1,57 @@
+/* Copyright (c) 2014, Rob Thornton
+ * All rights reserved.
+ * This source code is governed by a Simplied BSD-License. Please see the
+ * LICENSE included in this distribution for a copy of the full license
+ * or, if one is not included, you may also find a copy at
+ * http://opensource.org/licenses/BSD-2-Clause */
+
+#include "registers.h"
+#include "testing.h"
+
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+
+/* number of failed assertions, or -1 if no test is running */
+static int failures = -1;
+
+/* assertl reports a failed assertion at pos if the value at src is zero.
+ * Outside of a test the program exits, otherwise the test fails once it
+ * returns. */
+void assertl(const char *src, const char *pos, const char *msg) {
+	if (*(int32_t *)src != 0)
+		return;
+
+	if (*msg == '\0')
+		printf("%s assertion failed\n", pos);
+	else
+		printf("%s assertion failed: %s\n", pos, msg);
+	if (failures < 0) {
+		fflush(stdout);
+		exit(EXIT_FAILURE);
+	}
+	failures++;
+}
+
+/* test_run runs the test function fn, declared at pos. The test fails if
+ * any assertion fails or it returns zero. Returns 1 if the test failed,
+ * otherwise 0. */
+int test_run(void (*fn)(void), const char *name, const char *pos, int verbose) {
+	if (verbose)
+		printf("=== RUN   %s\n", name);
+
+	failures = 0;
+	fn();
+	if (*(int32_t *)eax == 0) {
+		printf("%s %s returned 0\n", pos, name);
+		failures++;
+	}
+
+	int failed = failures != 0;
+	failures = -1;
+	if (failed)
+		printf("--- FAIL: %s (%s)\n", name, pos);
+	else if (verbose)
+		printf("--- PASS: %s (%s)\n", name, pos);
+	return failed;
+}
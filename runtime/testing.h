@@ -0,0 +1,14 @@
+/* Copyright (c) 2014, Rob Thornton
+ * All rights reserved.
+ * This source code is governed by a Simplied BSD-License. Please see the
+ * LICENSE included in this distribution for a copy of the full license
+ * or, if one is not included, you may also find a copy at
+ * http://opensource.org/licenses/BSD-2-Clause */
+
+#ifndef RT_TESTING_H
+#define RT_TESTING_H
+
+void assertl(const char *src, const char *pos, const char *msg);
+int test_run(void (*fn)(void), const char *name, const char *pos, int verbose);
+
+#endif
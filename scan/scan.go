@@ -9,11 +9,40 @@
 package scan
 
 import (
+	"fmt"
+	"io"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/rthornton128/calc/token"
 )
 
+// Mode controls scanner behaviour. Modes may be combined using bitwise or.
+type Mode uint
+
+const (
+	// ScanComments causes comments to be returned as COMMENT tokens rather
+	// than being skipped over like whitespace
+	ScanComments Mode = 1 << iota
+)
+
+const bom = 0xFEFF // byte order mark, only permitted as the first character
+
+// An ErrorHandler is called for each error encountered while scanning, such
+// as an invalid UTF-8 encoding.
+type ErrorHandler func(pos token.Pos, msg string)
+
+// chunkSize is the number of bytes requested from a reader at a time
+const chunkSize = 4096
+
+// Token is a single token produced by the scanner
+type Token struct {
+	Pos token.Pos
+	Tok token.Token
+	Lit string
+}
+
 // Scanner...
 type Scanner struct {
 	ch      rune
@@ -21,18 +50,62 @@ type Scanner struct {
 	roffset int
 	src     string
 	file    *token.File
+	mode    Mode
+	err     ErrorHandler
+
+	r    io.Reader       // source of input when scanning a stream
+	rbuf []byte          // read buffer
+	buf  strings.Builder // input read so far
+
+	// ErrorCount is the number of errors encountered
+	ErrorCount int
+}
+
+// Init initializes Scanner and makes the source code ready to Scan. Errors
+// are reported to err, if not nil, and the mode determines how comments are
+// handled. A leading byte order mark is skipped.
+func (s *Scanner) Init(file *token.File, src string, err ErrorHandler,
+	mode Mode) {
+	s.init(file, src, nil, err, mode)
+}
+
+// InitReader initializes Scanner to read source code from r. Input is read
+// as it is needed to produce tokens rather than all at once. File must be
+// empty and the most recently added file of its fileset; it is appended to
+// as input is read. Errors reading from r are reported to err and treated
+// as the end of the input.
+func (s *Scanner) InitReader(file *token.File, r io.Reader, err ErrorHandler,
+	mode Mode) {
+	s.init(file, "", r, err, mode)
 }
 
-// Init initializes Scanner and makes the source code ready to Scan
-func (s *Scanner) Init(file *token.File, src string) {
+func (s *Scanner) init(file *token.File, src string, r io.Reader,
+	err ErrorHandler, mode Mode) {
 	s.file = file
 	s.offset, s.roffset = 0, 0
 	s.src = src
-	s.file.AddLine(s.offset)
+	s.r = r
+	s.buf.Reset()
+	s.err = err
+	s.mode = mode
+	s.ErrorCount = 0
+	if r != nil && s.rbuf == nil {
+		s.rbuf = make([]byte, chunkSize)
+	}
 
 	s.next()
+	if s.ch == bom {
+		s.next()
+	}
 }
 
+// Next is like Scan but returns the token as a Token
+func (s *Scanner) Next() Token {
+	lit, tok, pos := s.Scan()
+	return Token{Pos: pos, Tok: tok, Lit: lit}
+}
+
+// Scan returns the next token in the source
 func (s *Scanner) Scan() (lit string, tok token.Token, pos token.Pos) {
 	s.skipWhitespace()
 
@@ -40,14 +113,37 @@ func (s *Scanner) Scan() (lit string, tok token.Token, pos token.Pos) {
 		return s.scanIdentifier()
 	}
 
-	if unicode.IsDigit(s.ch) {
+	if isDigit(s.ch) {
 		return s.scanNumber()
 	}
 
-	ch := s.ch
+	if s.ch == '"' {
+		return s.scanString()
+	}
+
+	if s.ch == ';' || s.ch == '#' && (s.peek() == '|' || s.peek() == ';') {
+		var lit string
+		var pos token.Pos
+		switch {
+		case s.ch == ';':
+			lit, pos = s.scanComment()
+		case s.peek() == '|':
+			lit, pos = s.scanBlockComment()
+		default:
+			lit, pos = s.scanDatumComment()
+		}
+		if s.mode&ScanComments != 0 {
+			return lit, token.COMMENT, pos
+		}
+		return s.Scan()
+	}
+
+	ch, start := s.ch, s.offset
 	lit, pos = string(s.ch), s.file.Pos(s.offset)
 	s.next()
 	switch ch {
+	case -1:
+		lit, tok = "", token.EOF
 	case '(':
 		tok = token.LPAREN
 	case ')':
@@ -66,68 +162,329 @@ func (s *Scanner) Scan() (lit string, tok token.Token, pos token.Pos) {
 		tok = token.REM
 	case '<':
 		tok = s.selectToken('=', token.LTE, token.LST)
+		if tok == token.LST {
+			tok = s.selectToken('<', token.SHL, token.LST)
+		}
 	case '>':
 		tok = s.selectToken('=', token.GTE, token.GTT)
+		if tok == token.GTT {
+			tok = s.selectToken('>', token.SHR, token.GTT)
+		}
 	case '=':
 		tok = s.selectToken('=', token.EQL, token.ASSIGN)
 	case '!':
-		tok = s.selectToken('=', token.NEQ, token.ILLEGAL)
+		tok = s.selectToken('=', token.NEQ, token.NOT)
 	case '&':
-		tok = s.selectToken('&', token.AND, token.ILLEGAL)
+		tok = s.selectToken('&', token.AND, token.BAND)
+		if tok == token.BAND {
+			tok = s.selectToken('^', token.ANDNOT, token.BAND)
+		}
 	case '|':
-		tok = s.selectToken('|', token.OR, token.ILLEGAL)
-	case ';':
-		s.skipComment()
-		s.next()
-		return s.Scan()
+		tok = s.selectToken('|', token.OR, token.BOR)
+	case '^':
+		tok = token.XOR
 	default:
-		if s.offset >= len(s.src)-1 {
-			tok = token.EOF
-		} else {
-			tok = token.ILLEGAL
+		tok = token.ILLEGAL
+		// NUL, invalid encodings and byte order marks are reported by next
+		if ch != 0 && ch != utf8.RuneError && ch != bom {
+			s.error(start, fmt.Sprintf("illegal character %#U", ch))
 		}
 	}
+	if tok.IsOperator() {
+		lit = tok.String()
+	}
 
 	return
 }
 
+func (s *Scanner) error(offset int, msg string) {
+	if s.err != nil {
+		s.err(s.file.Pos(offset), msg)
+	}
+	s.ErrorCount++
+}
+
+// next reads the next Unicode character into s.ch. At the end of the source
+// s.ch is -1 and s.offset is the length of the source.
 func (s *Scanner) next() {
-	s.ch = rune(0)
-	if s.roffset < len(s.src) {
-		s.offset = s.roffset
-		s.ch = rune(s.src[s.offset])
-		if s.ch == '\n' {
-			s.file.AddLine(s.offset)
+	s.offset = s.roffset
+	if s.roffset >= len(s.src) && !s.fill() {
+		s.ch = -1
+		return
+	}
+
+	r, w := rune(s.src[s.roffset]), 1
+	switch {
+	case r == 0:
+		s.error(s.offset, "illegal character NUL")
+	case r >= utf8.RuneSelf:
+		for !utf8.FullRuneInString(s.src[s.roffset:]) && s.fill() {
+		}
+		r, w = utf8.DecodeRuneInString(s.src[s.roffset:])
+		if r == utf8.RuneError && w == 1 {
+			s.error(s.offset, "illegal UTF-8 encoding")
+		} else if r == bom && s.offset > 0 {
+			s.error(s.offset, "illegal byte order mark")
+		}
+	}
+	s.roffset += w
+	s.ch = r
+}
+
+// fill reads more input from the reader, if there is one, and reports
+// whether any was read
+func (s *Scanner) fill() bool {
+	for s.r != nil {
+		n, err := s.r.Read(s.rbuf)
+		if n > 0 {
+			chunk := string(s.rbuf[:n])
+			s.file.Append(chunk)
+			s.buf.WriteString(chunk)
+			s.src = s.buf.String()
 		}
-		s.roffset++
+		if err != nil {
+			if err != io.EOF {
+				s.error(len(s.src), "read error: "+err.Error())
+			}
+			s.r = nil
+		}
+		if n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// peek returns the character following s.ch without advancing the scanner
+// or -1 if there is none
+func (s *Scanner) peek() rune {
+	if s.roffset >= len(s.src) && !s.fill() {
+		return -1
+	}
+	for !utf8.FullRuneInString(s.src[s.roffset:]) && s.fill() {
+	}
+	r, _ := utf8.DecodeRuneInString(s.src[s.roffset:])
+	return r
+}
+
+func (s *Scanner) scanComment() (string, token.Pos) {
+	start := s.offset
+
+	for s.ch != '\n' && s.ch != -1 {
+		s.next()
 	}
+	return s.src[start:s.offset], s.file.Pos(start)
 }
 
+// scanBlockComment scans a #| ... |# comment. Block comments may be nested.
+func (s *Scanner) scanBlockComment() (string, token.Pos) {
+	start := s.offset
+	s.next()
+	s.next()
+
+	for depth := 1; depth > 0; {
+		switch {
+		case s.ch == -1:
+			s.error(start, "comment not terminated")
+			return s.src[start:s.offset], s.file.Pos(start)
+		case s.ch == '#' && s.peek() == '|':
+			s.next()
+			depth++
+		case s.ch == '|' && s.peek() == '#':
+			s.next()
+			depth--
+		}
+		s.next()
+	}
+	return s.src[start:s.offset], s.file.Pos(start)
+}
+
+// scanDatumComment scans a #; comment, which comments out the expression
+// following it
+func (s *Scanner) scanDatumComment() (string, token.Pos) {
+	start := s.offset
+	s.next()
+	s.next()
+	s.skipDatum(start)
+	return s.src[start:s.offset], s.file.Pos(start)
+}
+
+// skipDatum skips over the next expression. Errors are reported at the
+// offset of the datum comment, start.
+func (s *Scanner) skipDatum(start int) {
+	for {
+		s.skipWhitespace()
+		switch {
+		case s.ch == ';':
+			s.scanComment()
+			continue
+		case s.ch == '#' && s.peek() == '|':
+			s.scanBlockComment()
+			continue
+		case s.ch == '#' && s.peek() == ';':
+			s.scanDatumComment()
+			continue
+		case s.ch == '-' || s.ch == '+' || s.ch == '!' || s.ch == '^':
+			// unary operators are part of the expression that follows them
+			s.next()
+			continue
+		}
+		break
+	}
+
+	switch s.ch {
+	case -1, ')':
+		s.error(start, "missing expression after #;")
+	case '"':
+		s.scanString()
+	case '(':
+		s.next()
+		for depth := 1; depth > 0; {
+			switch {
+			case s.ch == -1:
+				s.error(start, "datum comment not terminated")
+				return
+			case s.ch == ';':
+				s.scanComment()
+				continue
+			case s.ch == '#' && s.peek() == '|':
+				s.scanBlockComment()
+				continue
+			case s.ch == '"':
+				s.scanString()
+				continue
+			case s.ch == '(':
+				depth++
+			case s.ch == ')':
+				depth--
+			}
+			s.next()
+		}
+	default:
+		atom := s.offset
+		for s.ch != -1 && s.ch != '(' && s.ch != ')' && s.ch != ';' &&
+			!unicode.IsSpace(s.ch) {
+			s.next()
+		}
+		if s.src[atom:s.offset] == "not" {
+			s.skipDatum(start)
+		}
+	}
+}
+
+// scanIdentifier scans an identifier or keyword. An identifier qualified by
+// a package name, like math.Square, is returned as a single IDENT token.
 func (s *Scanner) scanIdentifier() (string, token.Token, token.Pos) {
 	start := s.offset
 
 	for unicode.IsLetter(s.ch) || unicode.IsDigit(s.ch) {
 		s.next()
 	}
-	offset := s.offset
-	if s.ch == rune(0) {
-		offset++
+	if s.ch == '.' && unicode.IsLetter(s.peek()) {
+		s.next()
+		for unicode.IsLetter(s.ch) || unicode.IsDigit(s.ch) {
+			s.next()
+		}
+	}
+	lit := s.src[start:s.offset]
+	tok := token.Lookup(lit)
+	if !tok.IsKeyword() && !tok.IsOperator() {
+		tok = token.IDENT // such as an identifier named Integer
 	}
-	lit := s.src[start:offset]
-	return lit, token.Lookup(lit), s.file.Pos(start)
+	return lit, tok, s.file.Pos(start)
 }
 
+// scanString scans a double quoted string literal. The literal returned
+// includes the quotes. A backslash escapes the character following it.
+func (s *Scanner) scanString() (string, token.Token, token.Pos) {
+	start := s.offset
+	s.next()
+
+	for s.ch != '"' {
+		if s.ch == '\n' || s.ch == -1 {
+			s.error(start, "string literal not terminated")
+			return s.src[start:s.offset], token.ILLEGAL, s.file.Pos(start)
+		}
+		if s.ch == '\\' {
+			s.next()
+			if s.ch == '\n' || s.ch == -1 {
+				continue
+			}
+		}
+		s.next()
+	}
+	s.next()
+	return s.src[start:s.offset], token.STRING, s.file.Pos(start)
+}
+
+// scanNumber scans an integer literal. Hexadecimal, octal and binary
+// literals are prefixed by 0x, 0o and 0b respectively and underscores may
+// be used to separate digits. Any letters immediately following a number
+// are considered part of it so that a malformed literal, like 12abc, is
+// returned as a single ILLEGAL token.
 func (s *Scanner) scanNumber() (string, token.Token, token.Pos) {
 	start := s.offset
 
-	for unicode.IsDigit(s.ch) {
+	for unicode.IsLetter(s.ch) || unicode.IsDigit(s.ch) || s.ch == '_' {
 		s.next()
 	}
-	offset := s.offset
-	if s.ch == rune(0) {
-		offset++
+	lit := s.src[start:s.offset]
+	if msg := checkNumber(lit); msg != "" {
+		s.error(start, msg)
+		return lit, token.ILLEGAL, s.file.Pos(start)
 	}
-	return s.src[start:offset], token.INTEGER, s.file.Pos(start)
+	return lit, token.INTEGER, s.file.Pos(start)
+}
+
+// checkNumber returns a message describing why lit is not a valid integer
+// literal or an empty string if it is valid
+func checkNumber(lit string) string {
+	base, name, digits := 10, "decimal", lit
+	if len(lit) > 1 && lit[0] == '0' {
+		switch lit[1] {
+		case 'x', 'X':
+			base, name = 16, "hexadecimal"
+		case 'o', 'O':
+			base, name = 8, "octal"
+		case 'b', 'B':
+			base, name = 2, "binary"
+		default:
+			if isDigit(rune(lit[1])) || lit[1] == '_' {
+				return "invalid decimal literal " + lit +
+					", leading zeros are not permitted (use 0o for octal)"
+			}
+		}
+		if base != 10 {
+			digits = lit[2:]
+		}
+	}
+	if digits == "" {
+		return name + " literal has no digits"
+	}
+	for i, ch := range digits {
+		if ch == '_' {
+			if i == len(digits)-1 || digits[i+1] == '_' {
+				return "'_' must separate successive digits in " + lit
+			}
+			continue
+		}
+		if digitVal(ch) >= base {
+			return fmt.Sprintf("invalid digit %q in %s literal %s", ch, name, lit)
+		}
+	}
+	return ""
+}
+
+func digitVal(ch rune) int {
+	switch {
+	case '0' <= ch && ch <= '9':
+		return int(ch - '0')
+	case 'a' <= ch && ch <= 'f':
+		return int(ch - 'a' + 10)
+	case 'A' <= ch && ch <= 'F':
+		return int(ch - 'A' + 10)
+	}
+	return 16 // larger than any legal digit value
 }
 
 func (s *Scanner) selectToken(r rune, a, b token.Token) token.Token {
@@ -138,14 +495,12 @@ func (s *Scanner) selectToken(r rune, a, b token.Token) token.Token {
 	return b
 }
 
-func (s *Scanner) skipComment() {
-	for s.ch != '\n' && s.offset < len(s.src)-1 {
-		s.next()
-	}
-}
-
 func (s *Scanner) skipWhitespace() {
 	for unicode.IsSpace(s.ch) {
 		s.next()
 	}
 }
+
+func isDigit(ch rune) bool {
+	return '0' <= ch && ch <= '9'
+}
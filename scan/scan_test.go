@@ -8,7 +8,12 @@
 package scan_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/rthornton128/calc/scan"
 	"github.com/rthornton128/calc/token"
@@ -16,7 +21,7 @@ import (
 
 func test_handler(t *testing.T, src string, expected []token.Token) {
 	var s scan.Scanner
-	s.Init(token.NewFile("", 1, len(src)), src)
+	s.Init(token.NewFile("", 1, len(src)), src, nil, 0)
 	lit, tok, pos := s.Scan()
 	for i := 0; tok != token.EOF; i++ {
 		if tok != expected[i] {
@@ -96,21 +101,21 @@ func TestScanAllTokens(t *testing.T) {
 		token.INTEGER,
 		token.INTEGER,
 		token.INTEGER,
-		token.ILLEGAL,
+		token.BOR,
 		token.IDENT,
 		token.IDENT,
-		token.ILLEGAL,
+		token.NOT,
 		token.NEQ,
 		token.LST,
 		token.LTE,
-		token.ILLEGAL,
+		token.NOT,
 		token.ASSIGN,
 		token.EQL,
 		token.GTT,
 		token.GTE,
-		token.ILLEGAL,
+		token.BAND,
 		token.AND,
-		token.ILLEGAL,
+		token.BOR,
 		token.OR,
 		token.COMMA,
 		token.ILLEGAL,
@@ -119,3 +124,386 @@ func TestScanAllTokens(t *testing.T) {
 	}
 	test_handler(t, src, expected)
 }
+
+func TestScanBitwise(t *testing.T) {
+	var tests = []struct {
+		lit string
+		tok token.Token
+	}{
+		{"&", token.BAND},
+		{"|", token.BOR},
+		{"^", token.XOR},
+		{"&^", token.ANDNOT},
+		{"<<", token.SHL},
+		{">>", token.SHR},
+		{"<", token.LST},
+		{"<=", token.LTE},
+		{"&&", token.AND},
+		{"^", token.XOR},
+		{"a", token.IDENT},
+	}
+	src := "& | ^ &^ << >> < <= && ^a"
+
+	var s scan.Scanner
+	s.Init(token.NewFile("", 1, len(src)), src, nil, 0)
+	for i, v := range tests {
+		lit, tok, _ := s.Scan()
+		if tok != v.tok || lit != v.lit {
+			t.Fatal(i, "- Expected:", v.tok, v.lit, "Got:", tok, lit)
+		}
+	}
+}
+
+func TestScanComments(t *testing.T) {
+	var tests = []struct {
+		lit string
+		tok token.Token
+	}{
+		{"; first", token.COMMENT},
+		{"(", token.LPAREN},
+		{"+", token.ADD},
+		{"1", token.INTEGER},
+		{"2", token.INTEGER},
+		{")", token.RPAREN},
+		{"; trailing", token.COMMENT},
+		{";", token.COMMENT},
+	}
+	src := "; first\n(+ 1 2) ; trailing\n;"
+
+	var s scan.Scanner
+	s.Init(token.NewFile("", 1, len(src)), src, nil, scan.ScanComments)
+	for i, v := range tests {
+		lit, tok, _ := s.Scan()
+		if tok != v.tok || lit != v.lit {
+			t.Fatal(i, "- Expected:", v.tok, v.lit, "Got:", tok, lit)
+		}
+	}
+	if _, tok, _ := s.Scan(); tok != token.EOF {
+		t.Fatal("Expected: EOF Got:", tok)
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	src := "\uFEFF(décl größe δ 日本語1)"
+	fs := token.NewFileSet()
+	f := fs.Add("", src)
+
+	var tests = []struct {
+		lit string
+		tok token.Token
+		col int
+	}{
+		{"(", token.LPAREN, 1},
+		{"décl", token.IDENT, 2},
+		{"größe", token.IDENT, 7},
+		{"δ", token.IDENT, 13},
+		{"日本語1", token.IDENT, 15},
+		{")", token.RPAREN, 19},
+	}
+	var s scan.Scanner
+	s.Init(f, src, nil, 0)
+	for i, v := range tests {
+		lit, tok, pos := s.Scan()
+		if tok != v.tok || lit != v.lit || fs.Position(pos).Col != v.col {
+			t.Fatal(i, "- Expected:", v.tok, v.lit, v.col, "Got:", tok, lit,
+				fs.Position(pos).Col)
+		}
+	}
+	if s.ErrorCount != 0 {
+		t.Fatal("Expected: 0 errors Got:", s.ErrorCount)
+	}
+}
+
+func TestInvalidEncoding(t *testing.T) {
+	var tests = []struct {
+		src string
+		msg string
+	}{
+		{"(a \xff)", "illegal UTF-8 encoding"},
+		{"(a \uFEFF)", "illegal byte order mark"},
+		{"(a \x00)", "illegal character NUL"},
+	}
+	for _, v := range tests {
+		var msgs []string
+		var s scan.Scanner
+		s.Init(token.NewFile("", 1, len(v.src)), v.src,
+			func(pos token.Pos, msg string) {
+				if pos != token.Pos(4) {
+					t.Fatal("Expected: 4 Got:", pos)
+				}
+				msgs = append(msgs, msg)
+			}, 0)
+		for _, tok, _ := s.Scan(); tok != token.EOF; _, tok, _ = s.Scan() {
+		}
+		if len(msgs) != 1 || msgs[0] != v.msg || s.ErrorCount != 1 {
+			t.Fatal("Expected:", v.msg, "Got:", msgs)
+		}
+	}
+}
+
+func TestNumericLiterals(t *testing.T) {
+	var tests = []struct {
+		src string
+		tok token.Token
+		msg string
+	}{
+		{"0", token.INTEGER, ""},
+		{"1_000_000", token.INTEGER, ""},
+		{"0x1F", token.INTEGER, ""},
+		{"0XdeadBEEF", token.INTEGER, ""},
+		{"0x_ff", token.INTEGER, ""},
+		{"0o17", token.INTEGER, ""},
+		{"0b1010_0101", token.INTEGER, ""},
+		{"12abc", token.ILLEGAL, "invalid digit 'a' in decimal literal 12abc"},
+		{"0x", token.ILLEGAL, "hexadecimal literal has no digits"},
+		{"0o8", token.ILLEGAL, "invalid digit '8' in octal literal 0o8"},
+		{"0b102", token.ILLEGAL, "invalid digit '2' in binary literal 0b102"},
+		{"1__0", token.ILLEGAL, "'_' must separate successive digits in 1__0"},
+		{"10_", token.ILLEGAL, "'_' must separate successive digits in 10_"},
+		{"012", token.ILLEGAL, "invalid decimal literal 012, leading zeros " +
+			"are not permitted (use 0o for octal)"},
+	}
+	for _, v := range tests {
+		var msg string
+		var s scan.Scanner
+		s.Init(token.NewFile("", 1, len(v.src)), v.src,
+			func(pos token.Pos, m string) { msg = m }, 0)
+		lit, tok, _ := s.Scan()
+		if lit != v.src || tok != v.tok || msg != v.msg {
+			t.Fatal("Expected:", v.src, v.tok, v.msg, "Got:", lit, tok, msg)
+		}
+		if _, tok, _ = s.Scan(); tok != token.EOF {
+			t.Fatal("Expected: EOF Got:", tok)
+		}
+	}
+}
+
+func TestBlockComments(t *testing.T) {
+	var tests = []struct {
+		lit string
+		tok token.Token
+	}{
+		{"#| outer #| inner |# still outer |#", token.COMMENT},
+		{"(", token.LPAREN},
+		{"+", token.ADD},
+		{"1", token.INTEGER},
+		{"#; (* 2 (- 3 4))", token.COMMENT},
+		{"#;-x", token.COMMENT},
+		{"#;- 5", token.COMMENT},
+		{"#;not ^ ;c\n(f)", token.COMMENT},
+		{"#; #| skipped |# 5", token.COMMENT},
+		{`#; (f ")")`, token.COMMENT},
+		{`#;")"`, token.COMMENT},
+		{"2", token.INTEGER},
+		{")", token.RPAREN},
+		{"#|\nmulti\nline|#", token.COMMENT},
+	}
+	src := "#| outer #| inner |# still outer |#(+ 1 #; (* 2 (- 3 4)) " +
+		"#;-x #;- 5 #;not ^ ;c\n(f) #; #| skipped |# 5 #; (f \")\") #;\")\" 2)#|\nmulti\nline|#"
+
+	var s scan.Scanner
+	s.Init(token.NewFile("", 1, len(src)), src, nil, scan.ScanComments)
+	for i, v := range tests {
+		lit, tok, _ := s.Scan()
+		if tok != v.tok || lit != v.lit {
+			t.Fatal(i, "- Expected:", v.tok, v.lit, "Got:", tok, lit)
+		}
+	}
+	if _, tok, _ := s.Scan(); tok != token.EOF || s.ErrorCount != 0 {
+		t.Fatal("Expected: EOF and 0 errors Got:", tok, s.ErrorCount)
+	}
+
+	expected := []token.Token{token.LPAREN, token.ADD, token.INTEGER,
+		token.INTEGER, token.RPAREN, token.EOF}
+	test_handler(t, src, expected)
+}
+
+func TestUnterminatedComments(t *testing.T) {
+	var tests = []struct {
+		src string
+		pos token.Pos
+		msg string
+	}{
+		{"(+ 1 #| #| |# 2)", token.Pos(6), "comment not terminated"},
+		{"1 #; (+ 1 2", token.Pos(3), "datum comment not terminated"},
+		{"(+ 1 #;)", token.Pos(6), "missing expression after #;"},
+		{"#;", token.Pos(1), "missing expression after #;"},
+	}
+	for _, v := range tests {
+		var pos token.Pos
+		var msg string
+		var s scan.Scanner
+		s.Init(token.NewFile("", 1, len(v.src)), v.src,
+			func(p token.Pos, m string) { pos, msg = p, m }, 0)
+		for _, tok, _ := s.Scan(); tok != token.EOF; _, tok, _ = s.Scan() {
+		}
+		if pos != v.pos || msg != v.msg || s.ErrorCount != 1 {
+			t.Fatal(v.src, "Expected:", v.pos, v.msg, "Got:", pos, msg)
+		}
+	}
+}
+
+func TestScanReader(t *testing.T) {
+	src := "\uFEFF; größe\r\n(decl δ int\r\n  #| block |# (+ 0x1F 2)) ; end"
+
+	scanAll := func(fs *token.FileSet, s *scan.Scanner) []string {
+		var toks []string
+		for {
+			tok := s.Next()
+			toks = append(toks, tok.Tok.String()+" "+tok.Lit+" "+
+				fs.Position(tok.Pos).String())
+			if tok.Tok == token.EOF {
+				return toks
+			}
+		}
+	}
+
+	var s scan.Scanner
+	fs := token.NewFileSet()
+	s.Init(fs.Add("test.calc", src), src, nil, scan.ScanComments)
+	expected := scanAll(fs, &s)
+
+	fs = token.NewFileSet()
+	f := fs.Add("test.calc", "")
+	s.InitReader(f, iotest.OneByteReader(strings.NewReader(src)), nil,
+		scan.ScanComments)
+	toks := scanAll(fs, &s)
+	if strings.Join(toks, "\n") != strings.Join(expected, "\n") {
+		t.Fatal("Expected:", expected, "Got:", toks)
+	}
+	if f.Size() != len(src) || f.LineCount() != 3 || s.ErrorCount != 0 {
+		t.Fatal("Expected:", len(src), 3, 0, "Got:", f.Size(), f.LineCount(),
+			s.ErrorCount)
+	}
+}
+
+func TestScanReaderError(t *testing.T) {
+	var msg string
+	r := iotest.DataErrReader(iotest.TimeoutReader(strings.NewReader("(+ 1 2)")))
+	var s scan.Scanner
+	s.InitReader(token.NewFileSet().Add("", ""), r,
+		func(pos token.Pos, m string) { msg = m }, 0)
+	for tok := s.Next(); tok.Tok != token.EOF; tok = s.Next() {
+	}
+	if msg != "read error: "+iotest.ErrTimeout.Error() {
+		t.Fatal("Expected:", iotest.ErrTimeout, "Got:", msg)
+	}
+}
+
+func TestIllegalCharacter(t *testing.T) {
+	src := "(+ 1 $2)"
+	var pos token.Pos
+	var msg string
+	var s scan.Scanner
+	s.Init(token.NewFile("", 1, len(src)), src,
+		func(p token.Pos, m string) { pos, msg = p, m }, 0)
+	for tok := s.Next(); tok.Tok != token.EOF; tok = s.Next() {
+	}
+	if pos != token.Pos(6) || msg != "illegal character U+0024 '$'" {
+		t.Fatal("Expected: 6 illegal character U+0024 '$' Got:", pos, msg)
+	}
+}
+
+func TestImport(t *testing.T) {
+	src := "(import m \"lib/math\") (m.Square Integer a.b.c x. \"a\\\"b\")"
+	var s scan.Scanner
+	s.Init(token.NewFile("", 1, len(src)), src, nil, 0)
+	expected := []scan.Token{
+		{Tok: token.LPAREN, Lit: "("},
+		{Tok: token.IMPORT, Lit: "import"},
+		{Tok: token.IDENT, Lit: "m"},
+		{Tok: token.STRING, Lit: "\"lib/math\""},
+		{Tok: token.RPAREN, Lit: ")"},
+		{Tok: token.LPAREN, Lit: "("},
+		{Tok: token.IDENT, Lit: "m.Square"},
+		{Tok: token.IDENT, Lit: "Integer"},
+		{Tok: token.IDENT, Lit: "a.b"},
+		{Tok: token.ILLEGAL, Lit: "."},
+		{Tok: token.IDENT, Lit: "c"},
+		{Tok: token.IDENT, Lit: "x"},
+		{Tok: token.ILLEGAL, Lit: "."},
+		{Tok: token.STRING, Lit: "\"a\\\"b\""},
+		{Tok: token.RPAREN, Lit: ")"},
+		{Tok: token.EOF, Lit: ""},
+	}
+	for i, exp := range expected {
+		if tok := s.Next(); tok.Tok != exp.Tok || tok.Lit != exp.Lit {
+			t.Fatal(i, "- Expected:", exp.Tok, exp.Lit, "Got:", tok.Tok, tok.Lit)
+		}
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	src := "(import \"lib\n)"
+	var msg string
+	var s scan.Scanner
+	s.Init(token.NewFile("", 1, len(src)), src,
+		func(p token.Pos, m string) { msg = m }, 0)
+	for tok := s.Next(); tok.Tok != token.EOF; tok = s.Next() {
+	}
+	if msg != "string literal not terminated" {
+		t.Fatal("Expected: string literal not terminated Got:", msg)
+	}
+}
+
+// FuzzScanner checks that any input is scanned, whole or read a byte at a
+// time, into the same tokens without panicking, that each token is taken
+// from the source and that scanning ends at EOF. The seed corpus is the examples.
+func FuzzScanner(f *testing.F) {
+	addExamples(f)
+	f.Fuzz(func(t *testing.T, src string) {
+		fs := token.NewFileSet()
+		file := fs.Add("fuzz.calc", src)
+		var s scan.Scanner
+		s.Init(file, src, nil, scan.ScanComments)
+
+		var r scan.Scanner
+		rfile := token.NewFileSet().Add("fuzz.calc", "")
+		r.InitReader(rfile, iotest.OneByteReader(strings.NewReader(src)), nil,
+			scan.ScanComments)
+
+		for i := 0; ; i++ {
+			if i > len(src) {
+				t.Fatal("Expected: EOF after at most", len(src)+1, "tokens")
+			}
+			tok, rtok := s.Next(), r.Next()
+			// the literal of an illegal character is not always the source
+			// text, such as for an invalid encoding
+			off := int(tok.Pos) - file.Base()
+			if off < 0 || off > len(src) || tok.Tok != token.ILLEGAL &&
+				(off+len(tok.Lit) > len(src) || src[off:off+len(tok.Lit)] != tok.Lit) {
+				t.Fatal("Expected: token within source Got:", tok)
+			}
+			if rtok.Tok != tok.Tok || rtok.Lit != tok.Lit ||
+				int(rtok.Pos)-rfile.Base() != off {
+				t.Fatal("Expected:", tok, "from reader Got:", rtok)
+			}
+			if tok.Tok == token.EOF {
+				break
+			}
+		}
+		if r.ErrorCount != s.ErrorCount {
+			t.Fatal("Expected:", s.ErrorCount, "errors from reader Got:",
+				r.ErrorCount)
+		}
+	})
+}
+
+// addExamples adds the source of every file in the examples directory to
+// the seed corpus of f
+func addExamples(f *testing.F) {
+	err := filepath.Walk(filepath.Join("..", "examples"),
+		func(path string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() || filepath.Ext(path) != ".calc" {
+				return err
+			}
+			src, err := ioutil.ReadFile(path)
+			if err == nil {
+				f.Add(string(src))
+			}
+			return err
+		})
+	if err != nil {
+		f.Fatal(err)
+	}
+}
@@ -9,6 +9,7 @@ package token
 
 import (
 	"fmt"
+	"sort"
 )
 
 // Error represents an error in the source code. It consists of a position
@@ -23,6 +24,16 @@ func (e Error) Error() string {
 	return fmt.Sprint(e.pos, " ", e.msg)
 }
 
+// Pos returns the position within the source files of the error
+func (e Error) Pos() Position {
+	return e.pos
+}
+
+// Msg returns the message text of the error without the position
+func (e Error) Msg() string {
+	return e.msg
+}
+
 // ErrorList is a slice of Error pointers
 type ErrorList []*Error
 
@@ -36,6 +47,21 @@ func (el *ErrorList) Add(p Position, args ...interface{}) {
 	*el = append(*el, &Error{pos: p, msg: fmt.Sprint(args...)})
 }
 
+// Sort sorts the list by file name and then by position within the file.
+// Errors at the same position keep their order.
+func (el ErrorList) Sort() {
+	sort.SliceStable(el, func(i, j int) bool {
+		a, b := el[i].pos, el[j].pos
+		switch {
+		case a.Filename != b.Filename:
+			return a.Filename < b.Filename
+		case a.Row != b.Row:
+			return a.Row < b.Row
+		}
+		return a.Col < b.Col
+	})
+}
+
 func (el *ErrorList) cleanup() {
 	var last Position
 	i := 0
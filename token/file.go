@@ -7,32 +7,137 @@
 
 package token
 
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
 // File represents a single source file. It is used to track the number of
-// newlines in the file, it's size, name and position within a fileset.
+// newlines in the file, it's size, name and position within a fileset. A
+// File may be read from one goroutine while another appends to it.
 type File struct {
-	base  int
-	name  string
-	lines []int
-	size  int
+	mutex    sync.RWMutex // guards lines, size, src and tabWidth
+	base     int
+	name     string
+	lines    []int // offset of the first character of each line
+	size     int
+	src      string // source text, if known
+	tabWidth int
+
+	set *FileSet        // fileset the file belongs to, if any
+	buf strings.Builder // accumulates source text added by Append
 }
 
 // NewFile returns a new file object
 func NewFile(name string, base, size int) *File {
 	return &File{
-		base:  base,
-		name:  name,
-		lines: make([]int, 0, 16),
-		size:  size,
+		base:     base,
+		name:     name,
+		lines:    []int{0},
+		size:     size,
+		tabWidth: 1,
 	}
 }
 
-// AddLine adds the position of the start of a line in the source file at
-// the given offset. Every file consists of at least one line at offset
-// zero.
+// AddLine records a newline character at the given offset, starting a new
+// line immediately after it. Every file consists of at least one line at
+// offset zero so adding offset zero has no effect. Offsets must be added
+// in increasing order; any others are ignored.
 func (f *File) AddLine(offset int) {
-	if offset >= f.base-1 && offset < f.base+f.size {
-		f.lines = append(f.lines, offset)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if offset > 0 && offset < f.size && offset+1 > f.lines[len(f.lines)-1] {
+		f.lines = append(f.lines, offset+1)
+	}
+}
+
+// SetLines sets the line table of the file. Each entry is the offset of
+// the first character of a line, the first of which must be zero. The
+// offsets must be in strictly increasing order and less than the size of
+// the file. SetLines returns false, leaving the file unchanged, if lines is
+// invalid.
+func (f *File) SetLines(lines []int) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(lines) == 0 || lines[0] != 0 {
+		return false
+	}
+	for i := 1; i < len(lines); i++ {
+		if lines[i] <= lines[i-1] || lines[i] >= f.size {
+			return false
+		}
+	}
+	f.lines = lines
+	return true
+}
+
+// SetLinesForContent sets the line table from the given source text. A
+// line may be terminated by a newline, a carriage return or both.
+func (f *File) SetLinesForContent(src string) {
+	lines := scanLines([]int{0}, src, 0)
+	f.mutex.Lock()
+	f.lines = lines
+	f.mutex.Unlock()
+}
+
+// scanLines appends the offsets of the lines starting after offset from in
+// src to lines. A line terminator at the very end of src does not start a
+// new line since, for a carriage return, it is not yet known whether a
+// newline follows.
+func scanLines(lines []int, src string, from int) []int {
+	for i := from; i < len(src); i++ {
+		switch src[i] {
+		case '\r':
+			if i+1 < len(src) && src[i+1] == '\n' {
+				i++
+			}
+			fallthrough
+		case '\n':
+			if i+1 < len(src) {
+				lines = append(lines, i+1)
+			}
+		}
 	}
+	return lines
+}
+
+// Append adds src to the end of the file, growing it and extending its line
+// table. It allows a file to be built incrementally, such as while reading
+// from a stream. Only the most recently added file of a fileset may be
+// appended to.
+func (f *File) Append(src string) {
+	if f.set != nil {
+		f.set.mutex.Lock()
+		defer f.set.mutex.Unlock()
+		if f.set.files[len(f.set.files)-1] != f {
+			panic("append to file that is not the last in its fileset")
+		}
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.buf.Len() == 0 {
+		f.buf.WriteString(f.src)
+	}
+	f.buf.WriteString(src)
+	f.src = f.buf.String()
+	f.size += len(src)
+	f.lines = scanLines(f.lines, f.src, f.lines[len(f.lines)-1])
+	if f.set != nil {
+		f.set.base = f.base + f.size + 1
+	}
+}
+
+// SetTabWidth sets the number of columns a tab character advances to when
+// computing positions. The default width of one counts each tab as a
+// single column. The source text must be known for widths other than one.
+func (f *File) SetTabWidth(n int) {
+	if n < 1 {
+		n = 1
+	}
+	f.mutex.Lock()
+	f.tabWidth = n
+	f.mutex.Unlock()
 }
 
 // Base returns the base offset of the file within a fileset
@@ -40,29 +145,90 @@ func (f *File) Base() int {
 	return f.base
 }
 
+// LineCount returns the number of lines in the file
+func (f *File) LineCount() int {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return len(f.lines)
+}
+
 // Pos generates a Pos based on the offset. The position is the file's
-// base+offset
+// base+offset. An offset equal to the size of the file refers to the end
+// of the file. NoPos is returned for an offset outside the file.
 func (f *File) Pos(offset int) Pos {
-	if offset < 0 || offset >= f.size {
-		panic("illegal file offset")
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	if offset < 0 || offset > f.size {
+		return NoPos
 	}
 	return Pos(f.base + offset)
 }
 
 // Position returns the column and row position of a Pos within the file
 func (f *File) Position(p Pos) Position {
-	col, row := int(p)-f.Base()+1, 1
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	offset := int(p) - f.Base()
+	i := sort.Search(len(f.lines), func(i int) bool { return f.lines[i] > offset }) - 1
+	if i < 0 {
+		i = 0
+	}
+	return Position{
+		Filename: f.name,
+		Col:      f.column(f.lines[i], offset),
+		Row:      i + 1,
+	}
+}
 
-	for i, nl := range f.lines {
-		if p > f.Pos(nl) {
-			col, row = int(p-f.Pos(nl))-f.Base()+1, i+1
+// column returns the column of offset on the line beginning at start. The
+// caller must hold the mutex.
+// Columns are counted in characters when the source is known, otherwise in
+// bytes. A leading byte order mark does not occupy a column.
+func (f *File) column(start, offset int) int {
+	if f.src == "" || offset > len(f.src) {
+		return offset - start + 1
+	}
+	if start == 0 && strings.HasPrefix(f.src, "\uFEFF") && offset >= 3 {
+		start = 3
+	}
+	col := 0
+	for _, ch := range f.src[start:offset] {
+		if ch == '\t' {
+			col += f.tabWidth - col%f.tabWidth
+		} else {
+			col++
 		}
 	}
+	return col + 1
+}
+
+// Name returns the name of the file
+func (f *File) Name() string {
+	return f.name
+}
 
-	return Position{Filename: f.name, Col: col, Row: row}
+// End returns the position immediately after the last character in the
+// file
+func (f *File) End() Pos {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return Pos(f.base + f.size)
 }
 
 // Size returns the length of the source code of the file.
 func (f *File) Size() int {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
 	return f.size
 }
+
+// text returns the source text of the file between the offsets start and
+// end or an empty string if the source is unknown
+func (f *File) text(start, end int) string {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	if f.src == "" || end > len(f.src) {
+		return ""
+	}
+	return f.src[start:end]
+}
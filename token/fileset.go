@@ -7,35 +7,114 @@
 
 package token
 
-// FileSet holds all the files for the source code
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// FileSet holds all the files for the source code. It is safe for
+// concurrent use.
 type FileSet struct {
-	base  int
-	files []*File
+	mutex    sync.RWMutex
+	base     int
+	files    []*File
+	tabWidth int
 }
 
 // NewFileSet creates a new FileSet object
 func NewFileSet() *FileSet {
-	return &FileSet{base: 1}
+	return &FileSet{base: 1, tabWidth: 1}
 }
 
-// Add appends a new file to the fileset
+// Add appends a new file to the fileset. The line table of the file is
+// computed from src.
 func (fs *FileSet) Add(name, src string) *File {
+	return fs.add(name, src, scanLines([]int{0}, src, 0))
+}
+
+// AddWithLines appends a new file to the fileset using a precomputed line
+// table rather than scanning src for line endings. See File.SetLines for a
+// description of lines.
+func (fs *FileSet) AddWithLines(name, src string, lines []int) (*File, error) {
+	f := NewFile(name, 0, len(src))
+	if !f.SetLines(lines) {
+		return nil, errors.New("invalid line table for file " + name)
+	}
+	return fs.add(name, src, lines), nil
+}
+
+func (fs *FileSet) add(name, src string, lines []int) *File {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
 	f := NewFile(name, fs.base, len(src))
+	f.src = src
+	f.lines = lines
+	f.set = fs
+	f.SetTabWidth(fs.tabWidth)
 	fs.files = append(fs.files, f)
-	fs.base += len(src)
+	fs.base += len(src) + 1 // +1 so the end of one file is not the next
 	return f
 }
 
-// Position returns the row and column position of the given Pos p
+// SetTabWidth sets the tab width of all files in the fileset, including any
+// added later. See File.SetTabWidth.
+func (fs *FileSet) SetTabWidth(n int) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	fs.tabWidth = n
+	for _, f := range fs.files {
+		f.SetTabWidth(n)
+	}
+}
+
+// File returns the file containing p or nil if there is no such file. The
+// position immediately following the end of a file is considered part of
+// that file.
+func (fs *FileSet) File(p Pos) *File {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	i := sort.Search(len(fs.files), func(i int) bool {
+		return fs.files[i].End() >= p
+	})
+	if i < len(fs.files) && p >= Pos(fs.files[i].Base()) {
+		return fs.files[i]
+	}
+	return nil
+}
+
+// RangePosition returns the positions of the start and end of range r. The
+// end position refers to the character immediately following the range.
+func (fs *FileSet) RangePosition(r Range) (start, end Position) {
+	if f := fs.File(r.Start); f != nil && r.Valid() {
+		start, end = f.Position(r.Start), f.Position(r.End)
+	}
+	return
+}
+
+// Text returns the source text covered by range r or an empty string if
+// the range is invalid or the source is unknown
+func (fs *FileSet) Text(r Range) string {
+	f := fs.File(r.Start)
+	if f == nil || !r.Valid() || r.End > f.End() {
+		return ""
+	}
+	return f.text(int(r.Start)-f.base, int(r.End)-f.base)
+}
+
+// Position returns the row and column position of the given Pos p. The
+// Position of an invalid Pos, or one outside every file, is the zero
+// Position.
 func (fs *FileSet) Position(p Pos) Position {
 	var pos Position
 	if !p.Valid() {
-		panic("invalid position")
+		return pos
 	}
-	for _, f := range fs.files {
-		if p >= Pos(f.Base()) && p < Pos(f.Base()+f.Size()) {
-			pos = f.Position(p)
-		}
+	if f := fs.File(p); f != nil {
+		pos = f.Position(p)
 	}
 	return pos
 }
@@ -18,6 +18,22 @@ func (p Pos) Valid() bool {
 	return p != NoPos
 }
 
+// Range is the half-open interval [Start, End) of source code covered by a
+// node or token
+type Range struct {
+	Start, End Pos
+}
+
+// Valid returns true if both the start and end of the range are valid
+func (r Range) Valid() bool {
+	return r.Start.Valid() && r.End.Valid() && r.Start <= r.End
+}
+
+// Contains returns true if p lies within the range
+func (r Range) Contains(p Pos) bool {
+	return r.Start <= p && p < r.End
+}
+
 type Position struct {
 	Filename string
 	Col, Row int
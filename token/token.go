@@ -14,10 +14,12 @@ const (
 
 	EOF
 	ILLEGAL
+	COMMENT
 
 	lit_start
 	IDENT
 	INTEGER
+	STRING
 	lit_end
 
 	op_start
@@ -35,6 +37,14 @@ const (
 
 	AND
 	OR
+	NOT
+
+	BAND
+	BOR
+	XOR
+	ANDNOT
+	SHL
+	SHR
 
 	EQL
 	NEQ
@@ -47,6 +57,7 @@ const (
 	key_start
 	DECL
 	IF
+	IMPORT
 	VAR
 	key_end
 
@@ -56,8 +67,10 @@ const (
 var tok_strings = map[Token]string{
 	EOF:     "EOF",
 	ILLEGAL: "Illegal",
+	COMMENT: "Comment",
 	IDENT:   "Identifier",
 	INTEGER: "Integer",
+	STRING:  "String",
 	LPAREN:  "(",
 	RPAREN:  ")",
 	COMMA:   ",",
@@ -69,6 +82,13 @@ var tok_strings = map[Token]string{
 	ASSIGN:  "=",
 	AND:     "&&",
 	OR:      "||",
+	NOT:     "!",
+	BAND:    "&",
+	BOR:     "|",
+	XOR:     "^",
+	ANDNOT:  "&^",
+	SHL:     "<<",
+	SHR:     ">>",
 	EQL:     "==",
 	NEQ:     "!=",
 	LST:     "<",
@@ -77,6 +97,7 @@ var tok_strings = map[Token]string{
 	GTE:     ">=",
 	DECL:    "decl",
 	IF:      "if",
+	IMPORT:  "import",
 	VAR:     "var",
 }
 
@@ -92,7 +113,15 @@ func (t Token) IsKeyword() bool {
 	return t > key_start && t < key_end
 }
 
+// aliases are alternate spellings of tokens, such as the word not for !
+var aliases = map[string]Token{
+	"not": NOT,
+}
+
 func Lookup(str string) Token {
+	if t, ok := aliases[str]; ok {
+		return t
+	}
 	for t, s := range tok_strings {
 		if s == str {
 			return t
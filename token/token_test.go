@@ -8,6 +8,8 @@
 package token_test
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/rthornton128/calc/token"
@@ -54,6 +56,16 @@ func TestFileSetPosition(t *testing.T) {
 	fs := token.NewFileSet()
 	fs.Add("testA.calc", test_expr)
 	fs.Add("testB.calc", test_expr)
+
+	if p := fs.Position(token.NoPos); p != (token.Position{}) {
+		t.Fatal("Expected: zero Position for NoPos Got:", p)
+	}
+	f := fs.Add("testC.calc", test_expr)
+	for _, offset := range []int{-1, len(test_expr) + 1} {
+		if p := f.Pos(offset); p != token.NoPos {
+			t.Fatal("Expected: NoPos for offset", offset, "Got:", p)
+		}
+	}
 }
 
 func TestLookup(t *testing.T) {
@@ -174,3 +186,200 @@ func TestValid(t *testing.T) {
 		}
 	}
 }
+
+func TestFileSetMultiFilePosition(t *testing.T) {
+	fs := token.NewFileSet()
+	fs.Add("testA.calc", test_expr)
+	f := fs.Add("testB.calc", test_expr)
+	f.AddLine(0)
+	f.AddLine(7)
+
+	var tests = []struct {
+		offset int
+		pos    string
+	}{
+		{0, "testB.calc:1:1"},
+		{3, "testB.calc:1:4"},
+		{8, "testB.calc:2:1"},
+		{13, "testB.calc:2:6"},
+	}
+	for _, v := range tests {
+		if p := fs.Position(f.Pos(v.offset)); p.String() != v.pos {
+			t.Fatal("For:", v.offset, "Expected:", v.pos, "Got:", p)
+		}
+	}
+}
+
+func TestFileSetRange(t *testing.T) {
+	fs := token.NewFileSet()
+	fs.Add("testA.calc", test_expr)
+	f := fs.Add("testB.calc", test_expr)
+	f.AddLine(0)
+	f.AddLine(7)
+
+	r := token.Range{Start: f.Pos(2), End: f.Pos(11)}
+	if !r.Valid() || !r.Contains(f.Pos(2)) || r.Contains(f.Pos(11)) {
+		t.Fatal("Unexpected range validity or containment for", r)
+	}
+	start, end := fs.RangePosition(r)
+	if start.String() != "testB.calc:1:3" || end.String() != "testB.calc:2:4" {
+		t.Fatal("Expected: testB.calc:1:3 testB.calc:2:4 Got:", start, end)
+	}
+	if text := fs.Text(r); text != " 2 3)\n(- " {
+		t.Fatalf("Expected: %q Got: %q", " 2 3)\n(- ", text)
+	}
+
+	// range ending at the end of the file
+	r = token.Range{Start: f.Pos(8), End: f.End()}
+	if text := fs.Text(r); text != "(- 5 4)" {
+		t.Fatalf("Expected: %q Got: %q", "(- 5 4)", text)
+	}
+	if text := fs.Text(token.Range{}); text != "" {
+		t.Fatalf("Expected: empty string Got: %q", text)
+	}
+}
+
+func TestFileSetLineEndings(t *testing.T) {
+	var tests = []struct {
+		src      string
+		offset   int
+		row, col int
+	}{
+		{"(a)\n(b)", 4, 2, 1},
+		{"(a)\r\n(b)", 5, 2, 1},
+		{"(a)\r(b)", 4, 2, 1},
+		{"(a)\r\n\r\n(b)", 7, 3, 1},
+		{"(a)\n\n\n(b)", 7, 4, 2},
+	}
+	for _, test := range tests {
+		fs := token.NewFileSet()
+		f := fs.Add("", test.src)
+		p := fs.Position(f.Pos(test.offset))
+		if p.Row != test.row || p.Col != test.col {
+			t.Fatal("Expected:", test.row, test.col, "Got:", p.Row, p.Col)
+		}
+	}
+}
+
+func TestFileSetTabWidth(t *testing.T) {
+	fs := token.NewFileSet()
+	f := fs.Add("", "\t(a\tb)")
+	if p := fs.Position(f.Pos(1)); p.Col != 2 {
+		t.Fatal("Expected: 2 Got:", p.Col)
+	}
+	fs.SetTabWidth(8)
+	if p := fs.Position(f.Pos(1)); p.Col != 9 {
+		t.Fatal("Expected: 9 Got:", p.Col)
+	}
+	if p := fs.Position(f.Pos(4)); p.Col != 17 {
+		t.Fatal("Expected: 17 Got:", p.Col)
+	}
+}
+
+func TestFileSetAddWithLines(t *testing.T) {
+	fs := token.NewFileSet()
+	if _, err := fs.AddWithLines("bad", test_expr, []int{0, 20}); err == nil {
+		t.Fatal("Expected: error Got: nil")
+	}
+	f, err := fs.AddWithLines("test.calc", test_expr, []int{0, 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := fs.Position(f.Pos(13)); p.String() != "test.calc:2:6" {
+		t.Fatal("Expected: test.calc:2:6 Got:", p.String())
+	}
+	if fs.File(f.Pos(0)) != f || fs.File(f.End()+1) != nil {
+		t.Fatal("Expected: File to find only its own positions")
+	}
+}
+
+func TestFileAppend(t *testing.T) {
+	fs := token.NewFileSet()
+	f := fs.Add("stream.calc", "")
+	for _, chunk := range []string{"(+ 2", " 3)\r", "\n(- 5", " 4)"} {
+		f.Append(chunk)
+	}
+	if f.Size() != 16 || f.LineCount() != 2 {
+		t.Fatal("Expected: 16 2 Got:", f.Size(), f.LineCount())
+	}
+	if p := fs.Position(f.Pos(9)); p.String() != "stream.calc:2:1" {
+		t.Fatal("Expected: stream.calc:2:1 Got:", p.String())
+	}
+	if text := fs.Text(token.Range{Start: f.Pos(9), End: f.End()}); text != "(- 5 4)" {
+		t.Fatal("Expected: (- 5 4) Got:", text)
+	}
+
+	g := fs.Add("next.calc", "1")
+	if fs.File(g.Pos(0)) != g || fs.File(f.End()) != f {
+		t.Fatal("Expected: appended file to precede the next file")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected: panic appending to a file that is not last")
+		}
+	}()
+	f.Append("2")
+}
+
+func TestFileSetConcurrentAdd(t *testing.T) {
+	fs := token.NewFileSet()
+	files := make([]*token.File, 100)
+	var wg sync.WaitGroup
+	for i := range files {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			files[i] = fs.Add(fmt.Sprint(i), "(+ 1\n 2)")
+			fs.Position(files[i].Pos(5))
+		}(i)
+	}
+	wg.Wait()
+	for i, f := range files {
+		if fs.File(f.Pos(0)) != f {
+			t.Fatal("Expected: file", i, "Got:", fs.File(f.Pos(0)).Name())
+		}
+		if p := fs.Position(f.Pos(6)); p.String() != fmt.Sprint(i)+":2:2" {
+			t.Fatal("Expected:", fmt.Sprint(i)+":2:2", "Got:", p)
+		}
+	}
+}
+
+func TestErrorListSort(t *testing.T) {
+	var list token.ErrorList
+	list.Add(token.Position{Filename: "b.calc", Row: 1, Col: 2}, "b1")
+	list.Add(token.Position{Filename: "a.calc", Row: 2, Col: 1}, "a2")
+	list.Add(token.Position{Filename: "a.calc", Row: 1, Col: 9}, "a1")
+	list.Add(token.Position{Filename: "a.calc", Row: 2, Col: 1}, "a3")
+	list.Sort()
+	for i, exp := range []string{"a1", "a2", "a3", "b1"} {
+		if list[i].Msg() != exp {
+			t.Fatal("Expected:", exp, "Got:", list[i].Msg())
+		}
+	}
+}
+
+// TestFileConcurrentAppend reads a file while it is appended to, as when
+// streaming input is read by another goroutine. Run with -race.
+func TestFileConcurrentAppend(t *testing.T) {
+	fs := token.NewFileSet()
+	f := fs.Add("stream.calc", "")
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			f.Append("(+ 1\n 2)")
+		}
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			if p := fs.Position(f.Pos(6)); p.String() != "stream.calc:2:2" {
+				t.Fatal("Expected: stream.calc:2:2 Got:", p)
+			}
+			return
+		default:
+			fs.Position(f.End())
+			fs.Text(token.Range{Start: f.Pos(0), End: f.End()})
+		}
+	}
+}